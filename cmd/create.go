@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/f4t/opsctl/services"
+	"github.com/spf13/cobra"
+)
+
+var createRcValues []string
+var createDisabled bool
+
+// createCmd represents the create command
+var createCmd = &cobra.Command{
+	Use:   "create <instance type> <instance name> [--set KEY=VALUE ...]",
+	Short: "Create a new service instance.",
+	Long: `Create a new service instance.
+
+Creates the instance directory with its rc file, the files and directories
+expected by the package, and enables it. All mandatory rc variables of the
+package must be set.
+Example:
+
+# Create a netprobe listening on port 7036
+create netprobe myprobe --set NETPROBE_LISTEN_PORT=7036
+
+# Create a logstash instance, left disabled
+create logstash mylogstash --set LOGSTASH_HTTP_API_PORT=9600 --disabled
+
+# Export JAVA_HOME to the process and add arguments to its command line
+create logstash mylogstash --set LOGSTASH_HTTP_API_PORT=9600 \
+  --set ENV_JAVA_HOME=/opt/java --set EXTRA_ARGS="--log.level=debug"
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		doCreateInstance(args[0], args[1])
+	},
+}
+
+func doCreateInstance(instanceType string, instanceName string) {
+	rcValues := make(map[string]string)
+	for _, setting := range createRcValues {
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("Invalid --set '%s', expected KEY=VALUE", setting)
+		}
+		rcValues[parts[0]] = parts[1]
+	}
+
+	// Another opsctl run may create the same instance meanwhile
+	svc, release, err := makeLockedInstance(instanceType, instanceName)
+	if err != nil {
+		os.Exit(exitCode(err))
+	}
+	defer release()
+	instance := svc.Self()
+	err = instance.Create(rcValues, !createDisabled)
+	if err != nil {
+		instance.LogMsg(err.Error())
+		os.Exit(1)
+	}
+
+	// Re-load to check the instance is usable
+	svc, err = services.MakeInstance(instanceType, instanceName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	instance = svc.Self()
+	if instance.Errors.Config != nil {
+		instance.LogMsg(instance.Errors.Config.Error())
+		os.Exit(1)
+	}
+	instance.LogMsg("created at " + instance.Config.Workdir)
+}
+
+func init() {
+	rootCmd.AddCommand(createCmd)
+	createCmd.Flags().StringArrayVar(&createRcValues, "set", []string{}, "Set an rc variable, KEY=VALUE. Can be repeated.")
+	createCmd.Flags().BoolVar(&createDisabled, "disabled", false, "Do not enable the new instance.")
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// disableCmd represents the disable command
+var disableCmd = &cobra.Command{
+	Use:   "disable <instance type> <instance name>",
+	Short: "Disable a service instance, stopping it first.",
+	Long: `Disable a service instance, stopping it first if it is running.
+Disabled instances are not started, by 'start all' or otherwise.
+Example:
+
+disable <instance type> <instance name>
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		doDisableInstance(args[0], args[1])
+	},
+}
+
+func doDisableInstance(instanceType string, instanceName string) {
+	svc, release, err := makeLockedInstance(instanceType, instanceName)
+	if err != nil {
+		os.Exit(exitCode(err))
+	}
+	defer release()
+	instance := svc.Self()
+	if !instance.State.Exists {
+		instance.LogMsg(instance.Errors.Exists.Error())
+		os.Exit(exitCode(instance.Errors.Exists))
+	}
+	if instance.State.Up {
+		err = doStopInstance(instanceType, instanceName)
+		if err != nil {
+			instance.LogMsg("not disabled as it failed to stop")
+			os.Exit(exitCode(err))
+		}
+	}
+	if !instance.State.Enabled {
+		instance.LogMsg("already disabled")
+		return
+	}
+	err = instance.Disable()
+	journalAction("disable", instance, err)
+	if err != nil {
+		instance.LogMsg(err.Error())
+		os.Exit(exitCode(err))
+	}
+	instance.LogMsg("disabled")
+}
+
+func init() {
+	rootCmd.AddCommand(disableCmd)
+}
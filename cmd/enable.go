@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// enableCmd represents the enable command
+var enableCmd = &cobra.Command{
+	Use:   "enable <instance type> <instance name>",
+	Short: "Enable a service instance.",
+	Long: `Enable a service instance, allowing it to be started.
+Example:
+
+enable <instance type> <instance name>
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		doEnableInstance(args[0], args[1])
+	},
+}
+
+func doEnableInstance(instanceType string, instanceName string) {
+	svc, release, err := makeLockedInstance(instanceType, instanceName)
+	if err != nil {
+		os.Exit(exitCode(err))
+	}
+	defer release()
+	instance := svc.Self()
+	if instance.State.Enabled {
+		instance.LogMsg("already enabled")
+		return
+	}
+	err = instance.Enable()
+	journalAction("enable", instance, err)
+	if err != nil {
+		instance.LogMsg(err.Error())
+		os.Exit(exitCode(err))
+	}
+	instance.LogMsg("enabled")
+}
+
+func init() {
+	rootCmd.AddCommand(enableCmd)
+}
@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/services"
+	"github.com/f4t/opsctl/utils"
+	"github.com/spf13/cobra"
+)
+
+var exporterListen string
+var exporterSizeInterval time.Duration
+
+// exporterCmd represents the exporter command
+var exporterCmd = &cobra.Command{
+	Use:   "exporter [--listen :9737]",
+	Short: "Serve instances metrics for Prometheus.",
+	Long: `Serve instances metrics for Prometheus.
+
+Metrics are served on /metrics and collected on each scrape, the same way as
+status and toolkit. Every instance metric is labelled with type and name, e.g.
+opsctl_instance_up{name="np1",type="netprobe"} 1
+
+Restarts are counted from changes of pid or start time seen by the exporter,
+so opsctl_instance_restarts_total starts from 0 when the exporter starts.
+Walking instance directories takes long on large data directories, so their
+sizes are computed in the background every --size-interval.
+Example:
+
+# Serve metrics on port 9737, on all interfaces
+opsctl exporter --listen :9737
+
+# Alert on instances which should be running, in Prometheus
+opsctl_instance_enabled == 1 and opsctl_instance_up == 0
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		exporter := &instanceExporter{
+			lastSeen: make(map[instance.InstanceRef]processIdentity),
+			restarts: make(map[instance.InstanceRef]int),
+			sizes:    make(map[instance.InstanceRef]dirSizes),
+		}
+		go exporter.refreshSizes(exporterSizeInterval)
+		http.Handle("/metrics", exporter)
+		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintln(w, `<html><head><title>opsctl exporter</title></head><body><h1>opsctl exporter</h1><p><a href="/metrics">Metrics</a></p></body></html>`)
+		})
+		log.Printf("Serving instances metrics on %s/metrics", exporterListen)
+		log.Fatal(http.ListenAndServe(exporterListen, nil))
+	},
+}
+
+// processIdentity tells apart successive processes of an instance
+type processIdentity struct {
+	pid       int
+	startTime time.Time
+}
+
+type instanceExporter struct {
+	// Scrapes are serialized to track restarts
+	mutex    sync.Mutex
+	lastSeen map[instance.InstanceRef]processIdentity
+	restarts map[instance.InstanceRef]int
+
+	// Directory sizes are refreshed in the background
+	sizesMutex sync.Mutex
+	sizes      map[instance.InstanceRef]dirSizes
+}
+
+type dirSizes struct {
+	workdir int64
+	data    int64
+}
+
+func (exporter *instanceExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	var body bytes.Buffer
+	err := utils.WriteMetrics(&body, exporter.collect())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(body.Bytes())
+}
+
+func (exporter *instanceExporter) collect() []*utils.Metric {
+	scrapeStart := time.Now()
+	// The exporter is long running: look at processes as they are now
+	utils.RefreshProcTable()
+
+	up := &utils.Metric{Name: "opsctl_instance_up", Help: "Whether the instance process is running.", Type: "gauge"}
+	enabled := &utils.Metric{Name: "opsctl_instance_enabled", Help: "Whether the instance is enabled.", Type: "gauge"}
+	healthy := &utils.Metric{Name: "opsctl_instance_healthy", Help: "Whether the liveness probes of a running instance pass.", Type: "gauge"}
+	configError := &utils.Metric{Name: "opsctl_instance_config_error", Help: "Whether the instance configuration failed to load.", Type: "gauge"}
+	pid := &utils.Metric{Name: "opsctl_instance_pid", Help: "Pid of the instance process.", Type: "gauge"}
+	startTime := &utils.Metric{Name: "opsctl_instance_start_time_seconds", Help: "Start time of the instance process since unix epoch in seconds.", Type: "gauge"}
+	threads := &utils.Metric{Name: "opsctl_instance_threads", Help: "Number of threads of the instance process.", Type: "gauge"}
+	rss := &utils.Metric{Name: "opsctl_instance_resident_memory_bytes", Help: "Resident memory size of the instance process in bytes.", Type: "gauge"}
+	cpu := &utils.Metric{Name: "opsctl_instance_cpu_seconds_total", Help: "User and system CPU time of the instance process in seconds.", Type: "counter"}
+	workdirSize := &utils.Metric{Name: "opsctl_instance_workdir_bytes", Help: "Size of the instance directory in bytes.", Type: "gauge"}
+	dataSize := &utils.Metric{Name: "opsctl_instance_data_dir_bytes", Help: "Size of the instance data directory in bytes.", Type: "gauge"}
+	restarts := &utils.Metric{Name: "opsctl_instance_restarts_total", Help: "Restarts of the instance seen by the exporter.", Type: "counter"}
+	scrapeDuration := &utils.Metric{Name: "opsctl_scrape_duration_seconds", Help: "Time spent collecting instances metrics.", Type: "gauge"}
+	scrapeError := &utils.Metric{Name: "opsctl_scrape_error", Help: "Whether instances could not be listed.", Type: "gauge"}
+
+	refs, err := instance.ListAllInstances()
+	if err != nil {
+		// Skip the round, the exporter keeps serving
+		log.Println(err)
+		refs = nil
+	}
+	scrapeError.Add(nil, boolValue(err != nil))
+	sizes := exporter.cachedSizes()
+
+	for _, ref := range refs {
+		labels := map[string]string{"type": ref.Type, "name": ref.Name}
+		svc, err := services.MakeInstance(ref.Type, ref.Name)
+		if err != nil {
+			// e.g. an unsupported instance type
+			configError.Add(labels, 1)
+			continue
+		}
+		report := svc.Self().ProcessReport()
+
+		up.Add(labels, boolValue(report.State == "UP"))
+		enabled.Add(labels, boolValue(report.Enabled))
+		configError.Add(labels, boolValue(svc.Self().Errors.Config != nil))
+		if size, found := sizes[ref]; found {
+			workdirSize.Add(labels, float64(size.workdir))
+			dataSize.Add(labels, float64(size.data))
+		}
+
+		if report.State == "UP" {
+			if report.Health != "" {
+				healthy.Add(labels, boolValue(report.Health == "HEALTHY"))
+			}
+			pid.Add(labels, float64(report.PID))
+			threads.Add(labels, float64(report.Threads))
+			rss.Add(labels, float64(report.RssBytes))
+			cpu.Add(labels, report.CPUSeconds)
+			if report.StartTime != nil {
+				startTime.Add(labels, float64(report.StartTime.Unix()))
+				exporter.observe(ref, processIdentity{pid: report.PID, startTime: *report.StartTime})
+			}
+		}
+		restarts.Add(labels, float64(exporter.restarts[ref]))
+	}
+
+	scrapeDuration.Add(nil, time.Since(scrapeStart).Seconds())
+	return []*utils.Metric{up, enabled, healthy, configError, pid, startTime, threads, rss, cpu, workdirSize, dataSize, restarts, scrapeDuration, scrapeError}
+}
+
+// refreshSizes computes the directory sizes of all instances every interval,
+// keeping the previous sizes when instances cannot be listed
+func (exporter *instanceExporter) refreshSizes(interval time.Duration) {
+	for ; ; time.Sleep(interval) {
+		refs, err := instance.ListAllInstances()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		sizes := make(map[instance.InstanceRef]dirSizes)
+		for _, ref := range refs {
+			workdir, data := instance.MakeGenericInstance(ref.Type, ref.Name).DirSizes()
+			sizes[ref] = dirSizes{workdir: workdir, data: data}
+		}
+		exporter.sizesMutex.Lock()
+		exporter.sizes = sizes
+		exporter.sizesMutex.Unlock()
+	}
+}
+
+func (exporter *instanceExporter) cachedSizes() map[instance.InstanceRef]dirSizes {
+	exporter.sizesMutex.Lock()
+	defer exporter.sizesMutex.Unlock()
+	return exporter.sizes
+}
+
+// observe counts a restart when a running instance has a different process
+// than the last time it was seen running
+func (exporter *instanceExporter) observe(ref instance.InstanceRef, identity processIdentity) {
+	last, seen := exporter.lastSeen[ref]
+	if seen && last != identity {
+		exporter.restarts[ref]++
+	}
+	exporter.lastSeen[ref] = identity
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func init() {
+	rootCmd.AddCommand(exporterCmd)
+	exporterCmd.Flags().StringVar(&exporterListen, "listen", ":9737", "Address to serve metrics on.")
+	exporterCmd.Flags().DurationVar(&exporterSizeInterval, "size-interval", 5*time.Minute, "How often to compute the sizes of instance directories.")
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/utils"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var historySince string
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history [<instance type> <instance name>] [--since <duration|time>]",
+	Short: "Show the audit journal of lifecycle actions.",
+	Long: `Show the audit journal of lifecycle actions.
+
+Every start, stop, restart, enable and disable is recorded in
+$OPSCTL_HOME/journal.jsonl with the invoking user, host, command line, pids
+before and after, outcome and the signal which ended the process, if any.
+Crashes found by 'opsctl supervise' are recorded too. Past 10MB, the journal
+is rotated to journal.jsonl.1 and so on, the 10 most recent are kept.
+Example:
+
+# Show all actions
+history
+
+# Show the actions on an instance within the last day
+history logstash mylogstash --since 24h
+
+# Show actions since a date, as JSON
+history --since "2021-05-03 10:00:00" -o json
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var filter *instance.InstanceRef
+		if len(args) == 2 {
+			filter = &instance.InstanceRef{Type: args[0], Name: args[1]}
+		} else if len(args) != 0 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		since, err := parseSince(historySince)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		selected, err := instance.ReadJournal(utils.LoadOpsctlEnv().Home, func(entry instance.JournalEntry) bool {
+			if filter != nil && (entry.Type != filter.Type || entry.Name != filter.Name) {
+				return false
+			}
+			return since.IsZero() || !entry.Time.Before(since)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		printHistory(selected)
+	},
+}
+
+func printHistory(entries []instance.JournalEntry) {
+	switch outputFormat {
+	case outputJSON:
+		out, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+		return
+	case outputTable:
+	default:
+		log.Printf("Unsupported output format '%s' for history, expected one of: table, json", outputFormat)
+		os.Exit(1)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Time", "User", "Host", "Instance", "Action", "Outcome", "PIDs", "Signal", "Command", "Error"})
+	for _, entry := range entries {
+		table.Append([]string{
+			entry.Time.Local().Format("2006-01-02 15:04:05"),
+			entry.User,
+			entry.Host,
+			fmt.Sprintf("%s/%s", entry.Type, entry.Name),
+			entry.Action,
+			entry.Outcome,
+			fmt.Sprintf("%s -> %s", historyPID(entry.PIDBefore), historyPID(entry.PIDAfter)),
+			entry.Signal,
+			entry.Command,
+			entry.Error,
+		})
+	}
+	table.Render()
+}
+
+func historyPID(pid int) string {
+	if pid == 0 {
+		return "-"
+	}
+	return strconv.Itoa(pid)
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.Flags().StringVar(&historySince, "since", "", "Only show actions since a duration ago (e.g. 1h) or a timestamp.")
+}
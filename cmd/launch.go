@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/f4t/opsctl/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	launchLimits     []string
+	launchWaitCgroup bool
+)
+
+// launchCmd is run by opsctl itself to start instance processes, see
+// utils.RunDetachedProcess
+var launchCmd = &cobra.Command{
+	Use:    utils.LauncherCommand + " [--limit NAME=VALUE]... [--wait-cgroup] -- <command> [<args>...]",
+	Short:  "Apply resource limits then exec a command.",
+	Hidden: true,
+	// Skip the output format check of the root command
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		values := make(map[string]string)
+		for _, limit := range launchLimits {
+			parts := strings.SplitN(limit, "=", 2)
+			if len(parts) != 2 {
+				fmt.Fprintf(os.Stderr, "opsctl launch: invalid limit '%s'\n", limit)
+				os.Exit(1)
+			}
+			values[parts[0]] = parts[1]
+		}
+		limits, err := utils.ParseProcessLimits(values)
+		if err == nil {
+			err = utils.Launch(limits, launchWaitCgroup, args)
+		}
+		// Written to the instance log
+		fmt.Fprintf(os.Stderr, "opsctl launch: %s\n", err)
+		os.Exit(1)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(launchCmd)
+	launchCmd.Flags().StringArrayVar(&launchLimits, "limit", []string{}, "Limit to apply, as an rc variable, e.g. LIMIT_NOFILE=65536.")
+	launchCmd.Flags().BoolVar(&launchWaitCgroup, "wait-cgroup", false, "Wait for fd 3 to be closed, once moved into a cgroup.")
+}
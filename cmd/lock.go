@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/services"
+	"github.com/f4t/opsctl/utils"
+)
+
+var lockWait time.Duration
+var lockNoWait bool
+
+// lockTimeout is how long to wait for locks held by other opsctl processes
+func lockTimeout() time.Duration {
+	if lockNoWait {
+		return 0
+	}
+	return lockWait
+}
+
+// lockInstance takes the locks of a mutating operation on an instance
+func lockInstance(target instance.Instance) (func(), error) {
+	release, err := target.Lock(lockTimeout())
+	if err != nil {
+		target.LogMsg(err.Error())
+		return nil, err
+	}
+	return release, nil
+}
+
+// makeLockedInstance locks an instance, then inspects it: its state may have
+// been changed by the previous holder of the lock
+func makeLockedInstance(instanceType string, instanceName string) (services.ServiceInterface, func(), error) {
+	svc, err := services.MakeInstance(instanceType, instanceName)
+	if err != nil {
+		log.Println(err)
+		return nil, nil, err
+	}
+	release, err := lockInstance(svc.Self())
+	if err != nil {
+		return nil, nil, err
+	}
+	utils.RefreshProcTable()
+	svc, err = services.MakeInstance(instanceType, instanceName)
+	if err != nil {
+		release()
+		log.Println(err)
+		return nil, nil, err
+	}
+	return svc, release, nil
+}
+
+// lockAll takes the home-wide lock of operations on all instances and package
+// changes, exiting when it cannot be taken
+func lockAll() func() {
+	env := utils.LoadOpsctlEnv()
+	lock, err := utils.AcquireLock(instance.HomeLockPath(env.Home), true, lockTimeout())
+	if err != nil {
+		log.Println(err)
+		os.Exit(exitLocked)
+	}
+	return func() { lock.Release() }
+}
@@ -0,0 +1,348 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/services"
+	"github.com/f4t/opsctl/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsLines    int
+	logsFollow   bool
+	logsSince    string
+	logsArchived bool
+
+	rotateForce        bool
+	rotateMaxSize      string
+	rotateMaxAge       time.Duration
+	rotateKeep         int
+	rotateMaxTotalSize string
+)
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs (<instance type> <instance name>|<instance type>/<instance name>...|all)",
+	Short: "Show and manage service instances logs.",
+	Long: `Show and manage service instances logs.
+
+Shows the instance log (<instance dir>/<instance type>.log) along with logs the
+service writes on its own, e.g. <instance dir>/logs/*.log for logstash.
+Lines of several instances or files are interleaved by timestamp and prefixed
+with their instance and file.
+
+Instance logs are archived, gzipped, into
+$OPSCTL_HOME/archived_logs/<instance type>/<instance name>/.
+Example:
+
+# Last 10 lines of an instance logs
+logs <instance type> <instance name>
+
+# Follow several instances, across log rotations
+logs netprobe/np1 netprobe/np2 -f
+
+# Everything logged by all instances in the last hour, archives included
+logs all --since 1h --archived
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		refs, err := parseLogsArgs(args)
+		if err != nil {
+			log.Println(err)
+			cmd.Help()
+			os.Exit(1)
+		}
+		since, err := parseSince(logsSince)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n := logsLines
+		if logsSince != "" && !cmd.Flags().Changed("lines") {
+			n = 0
+		}
+
+		sources := make([]logSource, 0)
+		for _, ref := range refs {
+			svc, err := services.MakeInstance(ref.Type, ref.Name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			instance := svc.Self()
+			if !instance.State.Exists {
+				instance.LogMsg(instance.Errors.Exists.Error())
+				os.Exit(1)
+			}
+			sources = append(sources, instanceLogSources(instance, len(refs) > 1, logsArchived)...)
+		}
+
+		// Where to follow from, before reading
+		offsets := make([]int64, len(sources))
+		for i, source := range sources {
+			if stat, err := os.Stat(source.path); err == nil {
+				offsets[i] = stat.Size()
+			}
+		}
+
+		read := make([][]utils.LogLine, 0)
+		for _, source := range sources {
+			readN := n
+			if !since.IsZero() {
+				readN = 0
+			}
+			lines, err := source.read(readN)
+			if err != nil {
+				log.Println(err)
+			}
+			read = append(read, filterSince(lines, since))
+		}
+		merged := utils.MergeLogLines(read)
+		if n > 0 && len(merged) > n {
+			merged = merged[len(merged)-n:]
+		}
+		for _, line := range merged {
+			printLogLine(line)
+		}
+
+		if !logsFollow {
+			return
+		}
+		lines := make(chan utils.LogLine)
+		stop := make(chan struct{})
+		for i, source := range sources {
+			go utils.FollowLog(source.label, source.path, offsets[i], lines, stop)
+		}
+		for line := range lines {
+			printLogLine(line)
+		}
+	},
+}
+
+// logSource is a log file to show, labelled with its instance and file name
+// when needed to tell sources apart. Archives of the file come first.
+type logSource struct {
+	label    string
+	path     string
+	archives []string
+}
+
+// read returns the last n lines of the archives then the file, as one log
+func (source logSource) read(n int) ([]utils.LogLine, error) {
+	lines := make([]utils.LogLine, 0)
+	var lastTime time.Time
+	for _, path := range append(source.archives, source.path) {
+		fileLines, err := utils.ReadLogLines(source.label, path, 0)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return lines, err
+		}
+		for _, line := range fileLines {
+			// Timestamps carry over from the previous file
+			if line.Time.IsZero() {
+				line.Time = lastTime
+			}
+			lastTime = line.Time
+			lines = append(lines, line)
+		}
+		if n > 0 && len(lines) > n {
+			lines = lines[len(lines)-n:]
+		}
+	}
+	return lines, nil
+}
+
+func instanceLogSources(instance instance.Instance, withInstance bool, withArchives bool) []logSource {
+	logFiles := instance.LogFiles()
+	sources := make([]logSource, 0)
+	for _, path := range logFiles {
+		parts := make([]string, 0)
+		if withInstance {
+			parts = append(parts, instance.Ref().String())
+		}
+		if len(logFiles) > 1 {
+			parts = append(parts, filepath.Base(path))
+		}
+		sources = append(sources, logSource{label: strings.Join(parts, " "), path: path})
+	}
+
+	if withArchives {
+		archives, err := utils.ArchivedLogs(instance.ArchivedLogsDir())
+		if err != nil && !os.IsNotExist(err) {
+			instance.LogMsg(err.Error())
+		}
+		// Archives are those of the instance log, the first source
+		for _, archive := range archives {
+			sources[0].archives = append(sources[0].archives, filepath.Join(instance.ArchivedLogsDir(), archive.Name()))
+		}
+	}
+	return sources
+}
+
+// parseLogsArgs accepts "<type> <name>", "<type>/<name>..." or "all"
+func parseLogsArgs(args []string) ([]instance.InstanceRef, error) {
+	if len(args) == 1 && args[0] == "all" {
+		return instance.DiscoverAllInstances(), nil
+	}
+	if len(args) == 2 && !strings.Contains(args[0], "/") && !strings.Contains(args[1], "/") {
+		return []instance.InstanceRef{{Type: args[0], Name: args[1]}}, nil
+	}
+	if len(args) == 0 {
+		return nil, errors.New("No instance given")
+	}
+	refs := make([]instance.InstanceRef, 0)
+	for _, arg := range args {
+		ref, err := instance.ParseInstanceRef(arg)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// parseSince accepts a duration (e.g. 1h) or a timestamp
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	errMsg := fmt.Sprintf("Invalid --since '%s', expected a duration (e.g. 1h) or a timestamp (e.g. 2021-05-03 10:00:00)", value)
+	return time.Time{}, errors.New(errMsg)
+}
+
+// Lines without a known timestamp are dropped when since is set
+func filterSince(lines []utils.LogLine, since time.Time) []utils.LogLine {
+	if since.IsZero() {
+		return lines
+	}
+	filtered := make([]utils.LogLine, 0)
+	for _, line := range lines {
+		if !line.Time.Before(since) {
+			filtered = append(filtered, line)
+		}
+	}
+	return filtered
+}
+
+func printLogLine(line utils.LogLine) {
+	if line.Source == "" {
+		fmt.Println(line.Text)
+	} else {
+		fmt.Printf("%s | %s\n", line.Source, line.Text)
+	}
+}
+
+var logsRotateCmd = &cobra.Command{
+	Use:   "rotate (<instance type> <instance name>|all)",
+	Short: "Rotate service instances logs.",
+	Long: `Rotate service instances logs.
+
+A log is rotated when it reaches LOG_MAX_SIZE (default 100M) or when the last
+rotation is older than LOG_MAX_AGE (e.g. 24h, disabled by default). Then the
+oldest archives beyond LOG_KEEP (default 10) files or LOG_MAX_TOTAL_SIZE are
+deleted. These rc variables can be overridden with flags.
+
+The log is copied then truncated, so running instances do not need a restart.
+Logs are also rotated when an instance starts.
+Example:
+
+# Rotate logs of all instances when due, e.g. from cron
+logs rotate all
+
+# Rotate the log of an instance now
+logs rotate <instance type> <instance name> --force
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		refs := make([]instance.InstanceRef, 0)
+		if len(args) == 1 && args[0] == "all" {
+			refs = instance.DiscoverAllInstances()
+		} else if len(args) == 2 {
+			refs = append(refs, instance.InstanceRef{Type: args[0], Name: args[1]})
+		} else {
+			cmd.Help()
+			os.Exit(1)
+		}
+
+		failed := false
+		for _, ref := range refs {
+			svc, err := services.MakeInstance(ref.Type, ref.Name)
+			if err != nil {
+				log.Println(err)
+				failed = true
+				continue
+			}
+			instance := svc.Self()
+			if !instance.State.Exists {
+				instance.LogMsg(instance.Errors.Exists.Error())
+				failed = true
+				continue
+			}
+			err = instance.RotateLogs(rotatePolicy(cmd, instance), rotateForce)
+			if err != nil {
+				instance.LogMsg(err.Error())
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// Flags set on the command line take precedence over the rc file
+func rotatePolicy(cmd *cobra.Command, instance instance.Instance) utils.RotatePolicy {
+	policy := instance.RotatePolicy()
+	if cmd.Flags().Changed("max-size") {
+		size, err := utils.ParseByteSize(rotateMaxSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		policy.MaxSize = size
+	}
+	if cmd.Flags().Changed("max-age") {
+		policy.MaxAge = rotateMaxAge
+	}
+	if cmd.Flags().Changed("keep") {
+		policy.Keep = rotateKeep
+	}
+	if cmd.Flags().Changed("max-total-size") {
+		size, err := utils.ParseByteSize(rotateMaxTotalSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		policy.MaxTotalSize = size
+	}
+	return policy
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	logsCmd.AddCommand(logsRotateCmd)
+	logsCmd.Flags().IntVarP(&logsLines, "lines", "n", 10, "Number of lines to show, 0 for all. All lines by default with --since.")
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Keep showing new lines, across log rotations.")
+	logsCmd.Flags().StringVar(&logsSince, "since", "", "Only show lines since a duration (e.g. 1h) or a timestamp (e.g. 2021-05-03 10:00:00).")
+	logsCmd.Flags().BoolVar(&logsArchived, "archived", false, "Include archived logs.")
+	logsRotateCmd.Flags().BoolVar(&rotateForce, "force", false, "Rotate even if not due.")
+	logsRotateCmd.Flags().StringVar(&rotateMaxSize, "max-size", "", "Rotate logs larger than this size, e.g. 100M. 0 disables.")
+	logsRotateCmd.Flags().DurationVar(&rotateMaxAge, "max-age", 0, "Rotate logs when the last rotation is older than this, e.g. 24h. 0 disables.")
+	logsRotateCmd.Flags().IntVar(&rotateKeep, "keep", 0, "Number of archived logs to keep. 0 keeps all.")
+	logsRotateCmd.Flags().StringVar(&rotateMaxTotalSize, "max-total-size", "", "Maximum total size of archived logs, e.g. 1G. 0 disables.")
+}
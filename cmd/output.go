@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/services"
+	"github.com/f4t/opsctl/utils"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputCSV   = "csv"
+)
+
+var outputFormat string
+
+// showEnv reveals the values exported through ENV_, see status --show-env
+var showEnv bool
+
+// reportDocument is the top level object of JSON and YAML outputs
+type reportDocument struct {
+	SchemaVersion int                       `json:"schema_version" yaml:"schema_version"`
+	Headlines     map[string]string         `json:"headlines,omitempty" yaml:"headlines,omitempty"`
+	Instances     []instance.InstanceReport `json:"instances" yaml:"instances"`
+}
+
+// CSV columns, in order. Only append new columns to keep the schema stable.
+var csvHeader = []string{
+	"schema_version", "type", "name", "workdir", "exists", "enabled", "state", "health", "pid",
+	"package_version", "start_time", "uptime_seconds", "threads", "dir_size_bytes", "data_size_bytes",
+	"rc_values", "startup_args", "errors", "rss_bytes", "cpu_seconds",
+	"listening_ports", "user",
+}
+
+func validateOutputFormat() {
+	switch outputFormat {
+	case outputTable, outputJSON, outputYAML, outputCSV:
+	default:
+		log.Printf("Unsupported output format '%s', expected one of: table, json, yaml, csv", outputFormat)
+		os.Exit(1)
+	}
+}
+
+func isMachineOutput() bool {
+	return outputFormat != outputTable
+}
+
+// renderReports prints instances in the machine-readable format selected with --output
+func renderReports(svcs []services.ServiceInterface, headlines map[string]string) {
+	reports := make([]instance.InstanceReport, 0)
+	for _, svc := range svcs {
+		report := svc.Self().Report()
+		if showEnv {
+			report.Env = svc.Self().ReportedEnv(true)
+			report.RcValues = svc.Self().ReportedRcValues(true)
+		}
+		reports = append(reports, report)
+	}
+	document := reportDocument{
+		SchemaVersion: instance.ReportSchemaVersion,
+		Headlines:     headlines,
+		Instances:     reports,
+	}
+
+	switch outputFormat {
+	case outputJSON:
+		out, err := json.MarshalIndent(document, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	case outputYAML:
+		out, err := yaml.Marshal(document)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(string(out))
+	case outputCSV:
+		writer := csv.NewWriter(os.Stdout)
+		writer.Write(csvHeader)
+		for _, report := range reports {
+			writer.Write(csvRecord(report))
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func csvRecord(report instance.InstanceReport) []string {
+	rcValues := make([]string, 0)
+	for k, v := range report.RcValues {
+		rcValues = append(rcValues, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(rcValues)
+
+	startTime := ""
+	if report.StartTime != nil {
+		startTime = report.StartTime.Format(time.RFC3339)
+	}
+	pid := ""
+	if report.PID > 0 {
+		pid = strconv.Itoa(report.PID)
+	}
+
+	return []string{
+		strconv.Itoa(instance.ReportSchemaVersion),
+		report.Type,
+		report.Name,
+		report.Workdir,
+		strconv.FormatBool(report.Exists),
+		strconv.FormatBool(report.Enabled),
+		report.State,
+		report.Health,
+		pid,
+		report.PackageVersion,
+		startTime,
+		strconv.FormatInt(report.UptimeSeconds, 10),
+		strconv.Itoa(report.Threads),
+		strconv.FormatInt(report.DirSizeBytes, 10),
+		strconv.FormatInt(report.DataSizeBytes, 10),
+		strings.Join(rcValues, ";"),
+		strings.Join(report.StartupArgs, " "),
+		strings.Join(report.Errors, ";"),
+		strconv.FormatInt(report.RssBytes, 10),
+		strconv.FormatFloat(report.CPUSeconds, 'f', 2, 64),
+		utils.JoinPorts(report.ListeningPorts),
+		report.User,
+	}
+}
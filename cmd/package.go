@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/packages/versions"
+	"github.com/f4t/opsctl/services"
+	"github.com/f4t/opsctl/utils"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var packageAlias string
+
+// packageCmd represents the package command
+var packageCmd = &cobra.Command{
+	Use:   "package",
+	Short: "Manage installed package versions.",
+	Long: `Manage installed package versions.
+
+Versions are installed in $OPSCTL_HOME/packages/<package type>/<version>/.
+Aliases such as active_prod are symlinks to a version. Instances use the
+version or alias set by INSTANCE_PACKAGE_VERSION in their rc file, active_prod
+by default, and pick up a new version on their next restart.
+Example:
+
+# Install a version from a tarball
+opsctl package install logstash 7.10.2 /tmp/logstash-7.10.2.tar.gz
+
+# List installed versions and aliases
+opsctl package list [<package type>]
+
+# Point active_prod to a version
+opsctl package activate logstash 7.10.2
+
+# Point active_prod back to the previously active version
+opsctl package rollback logstash
+`,
+}
+
+var packageInstallCmd = &cobra.Command{
+	Use:   "install <package type> <version> <tarball>",
+	Short: "Install a package version from a .tar.gz archive.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 3 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		defer lockAll()()
+		env := utils.LoadOpsctlEnv()
+		versionDir, err := versions.Install(env.Home, args[0], args[1], args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Installed %s %s in %s", args[0], args[1], versionDir)
+	},
+}
+
+var packageListCmd = &cobra.Command{
+	Use:   "list [<package type>]",
+	Short: "List installed package versions and aliases.",
+	Run: func(cmd *cobra.Command, args []string) {
+		env := utils.LoadOpsctlEnv()
+		packageTypes := args
+		if len(args) == 0 {
+			var err error
+			packageTypes, err = versions.Types(env.Home)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Type", "Version", "Aliases"})
+		for _, packageType := range packageTypes {
+			packageVersions, err := versions.List(env.Home, packageType)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, version := range packageVersions {
+				if version.IsAlias() {
+					continue
+				}
+				table.Append([]string{packageType, version.Name, strings.Join(version.Aliases, ", ")})
+			}
+		}
+		table.Render()
+	},
+}
+
+var packageActivateCmd = &cobra.Command{
+	Use:   "activate <package type> <version> [--alias active_prod]",
+	Short: "Point an alias to a package version.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		defer lockAll()()
+		env := utils.LoadOpsctlEnv()
+		previous, err := versions.Activate(env.Home, args[0], args[1], packageAlias)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if previous == "" {
+			log.Printf("%s %s now points to %s", args[0], packageAlias, args[1])
+		} else {
+			log.Printf("%s %s now points to %s (was %s)", args[0], packageAlias, args[1], previous)
+		}
+		printAffectedInstances(args[0], packageAlias)
+	},
+}
+
+var packageRollbackCmd = &cobra.Command{
+	Use:   "rollback <package type> [--alias active_prod]",
+	Short: "Point an alias back to its previous package version.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		defer lockAll()()
+		env := utils.LoadOpsctlEnv()
+		version, err := versions.Rollback(env.Home, args[0], packageAlias)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s %s rolled back to %s", args[0], packageAlias, version)
+		printAffectedInstances(args[0], packageAlias)
+	},
+}
+
+// printAffectedInstances lists instances using an alias, which will run the
+// new version once restarted
+func printAffectedInstances(packageType string, alias string) {
+	env := utils.LoadOpsctlEnv()
+	if _, err := os.Stat(filepath.Join(env.Home, "instances", packageType)); err != nil {
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Type", "Name", "State", "Change"})
+	for _, instanceName := range instance.DiscoverInstances(packageType) {
+		svc, err := services.MakeInstance(packageType, instanceName)
+		if err != nil {
+			continue
+		}
+		instance := svc.Self()
+		if instance.Config.RcValues["INSTANCE_PACKAGE_VERSION"] != alias {
+			continue
+		}
+		change := "on next start"
+		if instance.State.Up {
+			change = "on next restart"
+		}
+		table.Append([]string{packageType, instanceName, instance.StateName(), change})
+	}
+	fmt.Printf("Instances using %s/%s:\n", packageType, alias)
+	table.Render()
+}
+
+func init() {
+	rootCmd.AddCommand(packageCmd)
+	packageCmd.AddCommand(packageInstallCmd)
+	packageCmd.AddCommand(packageListCmd)
+	packageCmd.AddCommand(packageActivateCmd)
+	packageCmd.AddCommand(packageRollbackCmd)
+	packageActivateCmd.Flags().StringVar(&packageAlias, "alias", versions.DefaultAlias, "Alias to point to the version.")
+	packageRollbackCmd.Flags().StringVar(&packageAlias, "alias", versions.DefaultAlias, "Alias to roll back.")
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/services"
+	"github.com/f4t/opsctl/utils"
+	"github.com/olekukonko/tablewriter"
+)
+
+var dryRun bool
+
+// Planned actions of --dry-run, besides the action itself
+const (
+	planSkip = "skip"
+	planFail = "fail"
+)
+
+// planLayers returns the instances an action would apply to, in order, as
+// the action itself would run them
+func planLayers(action string, args []string) [][]instance.InstanceRef {
+	if isSingleInstance(args) {
+		ref := instance.InstanceRef{Type: args[0], Name: args[1]}
+		layers := make([][]instance.InstanceRef, 0)
+		if action == "start" && withDeps {
+			dependencies, err := services.DependenciesOf(ref)
+			if err != nil {
+				log.Fatal(err)
+			}
+			layers = append(layers, dependencies...)
+		}
+		return append(layers, []instance.InstanceRef{ref})
+	}
+
+	refs := mustSelectInstances(args)
+	if action == "restart" && rolling {
+		size := rollingBatch
+		if size < 1 {
+			size = 1
+		}
+		batches := make([][]instance.InstanceRef, 0)
+		for i := 0; i < len(refs); i += size {
+			end := i + size
+			if end > len(refs) {
+				end = len(refs)
+			}
+			batches = append(batches, refs[i:end])
+		}
+		return batches
+	}
+	layers, err := services.DependencyOrderOf(refs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if action == "stop" {
+		return instance.ReverseLayers(layers)
+	}
+	return layers
+}
+
+// planInstance tells what an action would do to an instance, and why
+func planInstance(action string, svc services.ServiceInterface) (string, string) {
+	target := svc.Self()
+	if !target.State.Exists {
+		return planFail, target.Errors.Exists.Error()
+	}
+	pid := fmt.Sprintf("running with pid=%d", target.State.PID)
+
+	switch action {
+	case "start":
+		if !target.State.Enabled {
+			return planSkip, "disabled"
+		}
+		if target.State.Up {
+			return planSkip, "already " + pid
+		}
+		if err := services.Preflight(svc); err != nil {
+			return planFail, fmt.Sprintf("preflight: %s", err)
+		}
+		return "start", "not running"
+	case "stop":
+		if !target.State.Up {
+			return planSkip, "already stopped"
+		}
+		return "stop", pid
+	default:
+		err := services.Preflight(svc)
+		if err != nil && instance.KindOf(err) == instance.KindDisabled {
+			if target.State.Up {
+				return "stop", pid + ", disabled so not started again"
+			}
+			return planSkip, "disabled"
+		}
+		// Restart leaves a running instance alone when it fails preflight
+		if err != nil && target.State.Up {
+			return planFail, fmt.Sprintf("preflight: %s, left %s", err, pid)
+		}
+		if err != nil {
+			return planFail, fmt.Sprintf("preflight: %s", err)
+		}
+		if !target.State.Up {
+			return "start", "not running"
+		}
+		return "restart", pid
+	}
+}
+
+// printPlan shows what an action would do, without touching any process
+func printPlan(action string, layers [][]instance.InstanceRef) {
+	utils.RefreshProcTable()
+	for i, layer := range layers {
+		for _, ref := range layer {
+			svc, err := services.MakeInstance(ref.Type, ref.Name)
+			if err != nil {
+				log.Printf("%s: %s", ref, err)
+				continue
+			}
+			target := svc.Self()
+			planned, reason := planInstance(action, svc)
+
+			tableData := [][]string{
+				{"Step", strconv.Itoa(i + 1)},
+				{"Instance", ref.String()},
+				{"Action", planned},
+				{"Reason", reason},
+			}
+			if target.State.Exists {
+				tableData = append(tableData,
+					[]string{"Startup args", utils.JoinArgs(target.Config.StartupArgs)},
+					[]string{"Runtime match", utils.JoinArgs(target.Config.RuntimeArgs)},
+					[]string{"Log", target.LogPath()},
+				)
+				for _, sidecar := range target.Config.Sidecars {
+					tableData = append(tableData,
+						[]string{"Sidecar", fmt.Sprintf("%s: %s", sidecar.Name, utils.JoinArgs(sidecar.StartupArgs))},
+					)
+				}
+				tableData = append(tableData,
+					[]string{"Startup grace period", target.Config.StartupGracePeriod.String()},
+					[]string{"Readiness timeout", target.ReadinessTimeout().String()},
+					[]string{"SIGTERM grace period", target.Config.SigtermGracePeriod.String()},
+					[]string{"SIGKILL grace period", target.Config.SigkillGracePeriod.String()},
+				)
+			}
+
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetAutoWrapText(false)
+			table.SetAlignment(tablewriter.ALIGN_LEFT)
+			table.AppendBulk(tableData)
+			table.Render()
+		}
+	}
+}
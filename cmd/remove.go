@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var removeArchive bool
+
+// removeCmd represents the remove command
+var removeCmd = &cobra.Command{
+	Use:   "remove <instance type> <instance name> --confirm [--archive]",
+	Short: "Remove a stopped service instance.",
+	Long: `Remove a stopped service instance, deleting its directory.
+Running instances must be stopped first.
+Example:
+
+# Remove an instance
+remove <instance type> <instance name> --confirm
+
+# Remove an instance, keeping a copy in $OPSCTL_HOME/archived_instances/<instance type>/
+remove <instance type> <instance name> --confirm --archive
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		if !confirm {
+			fmt.Println("--confirm is required when removing an instance")
+			os.Exit(1)
+		}
+		doRemoveInstance(args[0], args[1])
+	},
+}
+
+func doRemoveInstance(instanceType string, instanceName string) {
+	svc, release, err := makeLockedInstance(instanceType, instanceName)
+	if err != nil {
+		os.Exit(exitCode(err))
+	}
+	defer release()
+	instance := svc.Self()
+	archivePath, err := instance.Remove(removeArchive)
+	if err != nil {
+		instance.LogMsg(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if archivePath != "" {
+		instance.LogMsg("archived to " + archivePath)
+	}
+	instance.LogMsg("removed")
+}
+
+func init() {
+	rootCmd.AddCommand(removeCmd)
+	removeCmd.Flags().BoolVar(&confirm, "confirm", false, "Confirm the removal of the instance.")
+	removeCmd.Flags().BoolVar(&removeArchive, "archive", false, "Archive the instance directory before removing it.")
+}
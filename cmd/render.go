@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/spf13/cobra"
+)
+
+var renderForce bool
+
+// renderCmd represents the render command
+var renderCmd = &cobra.Command{
+	Use:   "render (<instance type> <instance name>|all) [--force]",
+	Short: "Render config files of instances from their templates.",
+	Long: `Render config files of instances from their templates.
+
+Every <instance dir>/<file>.tmpl is rendered to <instance dir>/<file> with Go
+text/template. Templates are given the rc values of the instance, e.g.
+{{.LOGSTASH_HTTP_API_PORT}}, as well as {{.Type}}, {{.Name}}, {{.Workdir}},
+{{.Home}} and {{.Version}}.
+
+Changes are shown as a diff before being written. Files edited by hand since
+they were last rendered are left untouched unless --force is set.
+Templates are also rendered when an instance starts, which fails on files
+edited by hand.
+Example:
+
+# Render the templates of an instance
+render logstash mylogstash
+
+# Overwrite files edited by hand
+render logstash mylogstash --force
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		refs := make([]instance.InstanceRef, 0)
+		if len(args) == 1 && args[0] == "all" {
+			defer lockAll()()
+			refs = instance.DiscoverAllInstances()
+		} else if len(args) == 2 {
+			refs = append(refs, instance.InstanceRef{Type: args[0], Name: args[1]})
+		} else {
+			cmd.Help()
+			os.Exit(1)
+		}
+
+		failed := false
+		for _, ref := range refs {
+			err := doRenderInstance(ref.Type, ref.Name)
+			if err != nil {
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func doRenderInstance(instanceType string, instanceName string) error {
+	svc, release, err := makeLockedInstance(instanceType, instanceName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	instance := svc.Self()
+	if !instance.State.Exists {
+		instance.LogMsg(instance.Errors.Exists.Error())
+		return instance.Errors.Exists
+	}
+	if instance.Errors.Config != nil {
+		instance.LogMsg(instance.Errors.Config.Error())
+		return instance.Errors.Config
+	}
+
+	files, err := instance.RenderTemplates()
+	if err != nil {
+		instance.LogMsg(err.Error())
+		return err
+	}
+	var refused error
+	for _, file := range files {
+		if !file.Changed() && !file.HandEdited {
+			continue
+		}
+		fmt.Print(file.Diff())
+		if file.Changed() && file.HandEdited && !renderForce {
+			refused = fmt.Errorf("%s was edited by hand, not overwritten without --force", file.Path)
+			instance.LogMsg(refused.Error())
+			continue
+		}
+		err = instance.WriteRendered(file)
+		if err != nil {
+			instance.LogMsg(err.Error())
+			return err
+		}
+		if file.Changed() {
+			instance.LogMsg(fmt.Sprintf("Rendered %s", file.Path))
+		}
+	}
+	return refused
+}
+
+func init() {
+	rootCmd.AddCommand(renderCmd)
+	renderCmd.Flags().BoolVar(&renderForce, "force", false, "Overwrite files edited by hand.")
+}
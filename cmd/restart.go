@@ -1,72 +1,216 @@
 package cmd
 
 import (
-	"fmt"
+	"errors"
 	"log"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/f4t/opsctl/instance"
 	"github.com/f4t/opsctl/services"
+	"github.com/f4t/opsctl/utils"
 	"github.com/spf13/cobra"
 )
 
+var rolling bool
+var rollingBatch int
+var gateTimeout time.Duration
+
 // restartCmd represents the restart command
 var restartCmd = &cobra.Command{
-	Use:   "restart (<instance type> <instance name>|all --confirm)",
+	Use:   "restart (<instance type> <instance name>|<selector>... [--confirm]|<instance type> --rolling)",
 	Short: "Restart service instances.",
 	Long: `Restart service instances.
+
+Instances failing their preflight checks, e.g. on a port conflict, are left
+running. Disabled instances are stopped and not started again.
 Example:
 
 # Restart a specific instance
 restart <instance type> <instance name>
 
-# Restart all instances at once
+# Restart all instances at once: all are stopped, dependents first, then
+# started, dependencies first (see DEPENDS_ON in rc files)
 restart all --confirm
-`,
+
+# Restart up to 4 independent instances at a time, 2s apart
+restart all --confirm --parallel 4 --stagger 2s
+
+# Restart all netprobe instances one at a time. Each one must be up, healthy
+# and listening on its ports before the next one is restarted, otherwise the
+# rollout stops and the remaining instances are left untouched.
+restart netprobe --rolling
+
+# Same, 2 instances at a time, waiting up to 2m for their ports
+restart netprobe --rolling --batch 2 --gate-timeout 2m
+
+# Restart the prod- netprobe instances one at a time
+restart netprobe/prod-* --rolling
+
+# Show what restarting all instances would do, without doing it
+restart all --dry-run
+` + selectorHelp + exitCodesHelp,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) == 1 && args[0] == "all" {
-			if confirm {
-				doRestartAllInstances()
-			} else {
-				fmt.Println("--confirm is required when restartping all services at once")
-				os.Exit(1)
+		// A single type restarts all its instances with --rolling
+		if rolling && len(args) == 1 && args[0] != "all" && !strings.Contains(args[0], "/") {
+			args = []string{args[0] + "/*"}
+		}
+		if dryRun && (len(args) > 0 || hasSelectorFilters()) {
+			printPlan("restart", planLayers("restart", args))
+			return
+		}
+		// Other selections only lock the instances they act on
+		if selectsAll(args) {
+			defer lockAll()()
+		}
+		if rolling {
+			refs := mustSelectInstances(args)
+			if len(args) > 0 && args[0] == "all" {
+				requireConfirm(args, refs, "restarting")
 			}
+			printResults(doRollingRestart(refs))
+		} else if !isSingleInstance(args) && (len(args) > 0 || hasSelectorFilters()) {
+			refs := mustSelectInstances(args)
+			requireConfirm(args, refs, "restarting")
+			printResults(doRestartInstances(refs))
 		} else if len(args) == 2 {
 			instanceType := args[0]
 			instanceName := args[1]
-			doRestartInstance(instanceType, instanceName)
+			err := doRestartInstance(instanceType, instanceName)
+			if err != nil {
+				os.Exit(exitCode(err))
+			}
 		} else {
 			cmd.Help()
 		}
 	},
 }
 
-func doRestartAllInstances() {
-	for _, instanceType := range instance.DiscoverInstanceTypes() {
-		for _, instanceName := range instance.DiscoverInstances(instanceType) {
-			doRestartInstance(instanceType, instanceName)
+// doRestartInstances stops instances, dependents first, then starts them
+func doRestartInstances(refs []instance.InstanceRef) []actionResult {
+	// Check the order before stopping anything
+	_, err := services.DependencyOrderOf(refs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	results := doStopInstances(refs)
+	return append(results, doStartInstances(refs)...)
+}
+
+// doRollingRestart restarts instances --batch at a time, and stops at the
+// first batch with an instance which does not come back
+func doRollingRestart(refs []instance.InstanceRef) []actionResult {
+	size := rollingBatch
+	if size < 1 {
+		size = 1
+	}
+
+	results := make([]actionResult, 0)
+	for i := 0; i < len(refs); i += size {
+		end := i + size
+		if end > len(refs) {
+			end = len(refs)
+		}
+		batchResults := make([]actionResult, end-i)
+		var wg sync.WaitGroup
+		for j, ref := range refs[i:end] {
+			wg.Add(1)
+			go func(j int, ref instance.InstanceRef) {
+				defer wg.Done()
+				start := time.Now()
+				err := doRestartInstance(ref.Type, ref.Name)
+				if err == nil {
+					err = waitForListening(ref)
+				}
+				batchResults[j] = actionResult{
+					Ref:      ref,
+					Action:   "restart",
+					Err:      err,
+					Duration: time.Since(start),
+				}
+			}(j, ref)
+		}
+		wg.Wait()
+		results = append(results, batchResults...)
+
+		for _, result := range batchResults {
+			if result.Err != nil && !isSkipped(result.Err) {
+				log.Printf("Rolling restart aborted, %s did not come back", result.Ref)
+				for _, ref := range refs[end:] {
+					results = append(results, actionResult{
+						Ref:    ref,
+						Action: "restart",
+						Err:    skippedError{reason: "rollout aborted"},
+					})
+				}
+				return results
+			}
 		}
 	}
+	return results
 }
 
-func doRestartInstance(instanceType string, instanceName string) {
-	svc, err := services.MakeInstance(instanceType, instanceName)
+// waitForListening waits for a restarted instance to listen on its ports
+func waitForListening(ref instance.InstanceRef) error {
+	utils.RefreshProcTable()
+	svc, err := services.MakeInstance(ref.Type, ref.Name)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	instance := svc.Self()
+	if !instance.State.Up {
+		return errors.New("Instance is not running after start")
+	}
+	err = instance.WaitForPorts(gateTimeout)
+	if err != nil {
+		instance.LogMsg(err.Error())
+	}
+	return err
+}
+
+func doRestartInstance(instanceType string, instanceName string) (err error) {
+	svc, release, err := makeLockedInstance(instanceType, instanceName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	instance := svc.Self()
+	defer func() { journalAction("restart", instance, err) }()
 	instance.LogMsg("attempting restart")
-	err = instance.Preflight()
-	svc.Stop()
+	err = services.Preflight(svc)
+	disabled := instance.State.Exists && !instance.State.Enabled
+	// Leave a running instance alone when it could not be started again
+	if err != nil && !disabled {
+		return err
+	}
+	stopErr := svc.Stop()
+	if stopErr != nil {
+		return stopErr
+	}
+	// A disabled instance is only stopped
+	if err != nil {
+		return skippedDisabled
+	}
 	// Re-load state
+	utils.RefreshProcTable()
 	svc, _ = services.MakeInstance(instanceType, instanceName)
+	err = svc.Start()
 	if err != nil {
-		return
+		return err
 	}
-	svc.Start()
+	return checkInstanceState(instanceType, instanceName, true)
 }
 
 func init() {
 	rootCmd.AddCommand(restartCmd)
 	restartCmd.Flags().BoolVar(&confirm, "confirm", false, "Confirm flag is only required when restarting all services at once.")
+	restartCmd.Flags().IntVar(&parallel, "parallel", 1, "Number of instances stopped or started at once with 'all'.")
+	restartCmd.Flags().DurationVar(&stagger, "stagger", 0, "Delay between stopping or starting two instances with 'all'.")
+	restartCmd.Flags().BoolVar(&rolling, "rolling", false, "Restart the instances of a type a batch at a time.")
+	restartCmd.Flags().IntVar(&rollingBatch, "batch", 1, "Number of instances restarted at once with --rolling.")
+	restartCmd.Flags().DurationVar(&gateTimeout, "gate-timeout", 60*time.Second, "How long to wait for an instance to listen on its ports with --rolling.")
+	addSelectorFlags(restartCmd)
+	restartCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be done, without touching any process.")
 }
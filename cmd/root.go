@@ -1,11 +1,16 @@
 package cmd
 
 import (
+	"time"
+
 	"github.com/spf13/cobra"
 )
 
 // var cfgFile string
 var confirm bool
+var withDeps bool
+var parallel int
+var stagger time.Duration
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -20,6 +25,9 @@ to quickly create a Cobra application.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		validateOutputFormat()
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -30,4 +38,7 @@ func Execute() {
 
 func init() {
 	// cobra.OnInitialize(initConfig)
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputTable, "Output format of status and toolkit: table, json, yaml or csv.")
+	rootCmd.PersistentFlags().DurationVar(&lockWait, "wait", time.Minute, "How long to wait for other opsctl runs to release the instances, locked in $OPSCTL_HOME/locks/<type>/<name>.lock, or all of them in $OPSCTL_HOME/.opsctl.lock.")
+	rootCmd.PersistentFlags().BoolVar(&lockNoWait, "no-wait", false, "Fail at once when other opsctl runs hold the instances.")
 }
@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/services"
+	"github.com/f4t/opsctl/utils"
+	"github.com/olekukonko/tablewriter"
+)
+
+// actionResult is the outcome of a lifecycle action on one instance
+type actionResult struct {
+	Ref      instance.InstanceRef
+	Action   string
+	Err      error
+	Duration time.Duration
+}
+
+// skippedError reports an instance deliberately left untouched by an action
+type skippedError struct {
+	reason string
+	kind   instance.ErrorKind
+}
+
+// Disabled instances are skipped by start and restart
+var skippedDisabled = skippedError{"disabled", instance.KindDisabled}
+
+func (err skippedError) Error() string {
+	return err.reason
+}
+
+func isSkipped(err error) bool {
+	_, skipped := err.(skippedError)
+	return skipped
+}
+
+// Exit codes of lifecycle commands, see exitCodesHelp
+const (
+	exitFailure      = 1
+	exitNotFound     = 2
+	exitDisabled     = 3
+	exitBadConfig    = 4
+	exitStartTimeout = 5
+	exitKillFailed   = 6
+	exitLocked       = 7
+)
+
+const exitCodesHelp = `
+Exit codes:
+  0  Success. Instances skipped by 'all' (e.g. disabled) are not failures
+  1  Failure, or failures of different kinds with 'all'
+  2  Instance not found
+  3  Instance disabled
+  4  Invalid instance configuration
+  5  Instance not running or not ready in time after start
+  6  Instance still running after stop
+  7  Instance locked by another opsctl run, see --wait
+`
+
+// exitCode returns the exit code of a lifecycle command which failed with err
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	kind := instance.KindOf(err)
+	if skipped, ok := err.(skippedError); ok {
+		kind = skipped.kind
+	}
+	switch kind {
+	case instance.KindNotFound:
+		return exitNotFound
+	case instance.KindDisabled:
+		return exitDisabled
+	case instance.KindBadConfig:
+		return exitBadConfig
+	case instance.KindStartTimeout:
+		return exitStartTimeout
+	case instance.KindKillFailed:
+		return exitKillFailed
+	case instance.KindLocked:
+		return exitLocked
+	default:
+		return exitFailure
+	}
+}
+
+// runLayers applies action to all instances, one layer after the other.
+// Within a layer, up to --parallel instances are handled at once, each one
+// being started --stagger after the previous one.
+func runLayers(layers [][]instance.InstanceRef, action string, fn func(instance.InstanceRef) error) []actionResult {
+	workers := parallel
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]actionResult, 0)
+	for _, layer := range layers {
+		layerResults := make([]actionResult, len(layer))
+		slots := make(chan struct{}, workers)
+		var wg sync.WaitGroup
+		for i, ref := range layer {
+			if i > 0 && stagger > 0 {
+				time.Sleep(stagger)
+			}
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(i int, ref instance.InstanceRef) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				start := time.Now()
+				err := fn(ref)
+				layerResults[i] = actionResult{
+					Ref:      ref,
+					Action:   action,
+					Err:      err,
+					Duration: time.Since(start),
+				}
+			}(i, ref)
+		}
+		wg.Wait()
+		results = append(results, layerResults...)
+	}
+	return results
+}
+
+// checkInstanceState re-inspects an instance after an action to check its outcome
+func checkInstanceState(instanceType string, instanceName string, wantUp bool) error {
+	utils.RefreshProcTable()
+	svc, err := services.MakeInstance(instanceType, instanceName)
+	if err != nil {
+		return err
+	}
+	target := svc.Self()
+	if wantUp && !target.State.Up {
+		errMsg := "Instance is not running after start"
+		target.LogMsg(errMsg)
+		return instance.NewError(instance.KindStartTimeout, errors.New(errMsg))
+	}
+	if wantUp {
+		_, err := target.Health()
+		var sidecarDown instance.SidecarDownError
+		if errors.As(err, &sidecarDown) {
+			// The instance itself is up, only degraded
+			target.LogMsg(fmt.Sprintf("Instance is degraded: %s", err))
+		} else if err != nil {
+			errMsg := fmt.Sprintf("Instance is running but not healthy: %s", err)
+			target.LogMsg(errMsg)
+			return instance.NewError(instance.KindStartTimeout, errors.New(errMsg))
+		}
+	}
+	if !wantUp && target.State.Up {
+		errMsg := fmt.Sprintf("Instance is still running with pid=%d", target.State.PID)
+		target.LogMsg(errMsg)
+		return instance.NewError(instance.KindKillFailed, errors.New(errMsg))
+	}
+	return nil
+}
+
+// journalAction records the outcome of an action in the audit journal.
+// before is the instance as it was inspected before the action.
+func journalAction(action string, before instance.Instance, err error) {
+	entry := instance.JournalEntry{Action: action, Outcome: instance.OutcomeOK}
+	if isSkipped(err) {
+		entry.Outcome = instance.OutcomeSkipped
+		entry.Error = err.Error()
+	} else if err != nil {
+		entry.Outcome = instance.OutcomeFailed
+		entry.Error = err.Error()
+	}
+	if before.State.Up {
+		entry.PIDBefore = before.State.PID
+	}
+	utils.RefreshProcTable()
+	if up, pid := before.IsUp(); up {
+		entry.PIDAfter = pid
+	}
+	if journalErr := before.Journal(entry); journalErr != nil {
+		before.LogMsg(fmt.Sprintf("Failed writing the audit journal: %s", journalErr))
+	}
+}
+
+// printResults renders a summary of all actions and exits with an error
+// status if any of them failed: the exit code of the failures when they are
+// all of the same kind, 1 otherwise
+func printResults(results []actionResult) {
+	failures := 0
+	code := 0
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Type", "Name", "Action", "Result", "Duration", "Error"})
+	for _, result := range results {
+		status := "OK"
+		errMsg := ""
+		if isSkipped(result.Err) {
+			status = "SKIPPED"
+			errMsg = result.Err.Error()
+		} else if result.Err != nil {
+			status = "FAILED"
+			errMsg = result.Err.Error()
+			failures++
+			if code == 0 {
+				code = exitCode(result.Err)
+			} else if code != exitCode(result.Err) {
+				code = exitFailure
+			}
+		}
+		table.Append([]string{
+			result.Ref.Type,
+			result.Ref.Name,
+			result.Action,
+			status,
+			result.Duration.Round(10 * time.Millisecond).String(),
+			errMsg,
+		})
+	}
+	table.Render()
+
+	if failures > 0 {
+		fmt.Printf("%d of %d actions failed\n", failures, len(results))
+		os.Exit(code)
+	}
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/services"
+	"github.com/spf13/cobra"
+)
+
+// Selections of more instances than this require --confirm
+const confirmThreshold = 3
+
+var selectType string
+var selectState string
+var selectEnabled bool
+var selectLabels []string
+
+const selectorHelp = `
+Selecting instances:
+  <type>/<name>      Instances matching globs, e.g. netprobe/* or netprobe/prod-*
+  all                All instances, same as */*
+  --type <type>      Only instances of a type
+  --state <state>    Only instances in a state: up, down or disabled
+  --enabled          Only enabled instances
+  -l <key>=<value>   Only instances with a label, from LABELS=key=value,... in
+                     their rc file. Repeat to require several labels.
+Without instance arguments, filters apply to all instances.
+`
+
+func addSelectorFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&selectType, "type", "", "Only select instances of this type.")
+	cmd.Flags().StringVar(&selectState, "state", "", "Only select instances in this state: up, down or disabled.")
+	cmd.Flags().BoolVar(&selectEnabled, "enabled", false, "Only select enabled instances.")
+	cmd.Flags().StringArrayVarP(&selectLabels, "label", "l", nil, "Only select instances with this label, e.g. team=payments.")
+}
+
+func hasSelectorFilters() bool {
+	return selectType != "" || selectState != "" || selectEnabled || len(selectLabels) > 0
+}
+
+// isSingleInstance tells whether args are the plain <type> <name> form
+func isSingleInstance(args []string) bool {
+	return len(args) == 2 && !strings.Contains(args[0], "/") && !strings.Contains(args[1], "/") && !hasSelectorFilters()
+}
+
+// selectInstances returns the instances matching args and the selector flags
+func selectInstances(args []string) ([]instance.InstanceRef, error) {
+	patterns := make([]instance.InstanceRef, 0)
+	if len(args) == 2 && !strings.Contains(args[0], "/") && !strings.Contains(args[1], "/") {
+		patterns = append(patterns, instance.InstanceRef{Type: args[0], Name: args[1]})
+	} else {
+		for _, arg := range args {
+			if arg == "all" {
+				arg = "*/*"
+			}
+			pattern, err := instance.ParseInstanceRef(arg)
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		if !hasSelectorFilters() {
+			return nil, errors.New("No instance selected")
+		}
+		patterns = append(patterns, instance.InstanceRef{Type: "*", Name: "*"})
+	}
+	for _, pattern := range append(patterns, instance.InstanceRef{Type: selectType, Name: "*"}) {
+		if _, err := path.Match(pattern.Type, ""); err != nil {
+			errMsg := fmt.Sprintf("Invalid pattern '%s'", pattern)
+			return nil, errors.New(errMsg)
+		}
+		if _, err := path.Match(pattern.Name, ""); err != nil {
+			errMsg := fmt.Sprintf("Invalid pattern '%s'", pattern)
+			return nil, errors.New(errMsg)
+		}
+	}
+
+	state := strings.ToUpper(selectState)
+	switch state {
+	case "", "UP", "DOWN", "DISABLED":
+	default:
+		errMsg := fmt.Sprintf("Invalid --state '%s', expected one of: up, down, disabled", selectState)
+		return nil, errors.New(errMsg)
+	}
+	labels, err := instance.ParseLabels(strings.Join(selectLabels, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]instance.InstanceRef, 0)
+	for _, ref := range instance.DiscoverAllInstances() {
+		if selectType != "" && !matchPattern(selectType, ref.Type) {
+			continue
+		}
+		matched := false
+		for _, pattern := range patterns {
+			if matchPattern(pattern.Type, ref.Type) && matchPattern(pattern.Name, ref.Name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		if state != "" || selectEnabled || len(labels) > 0 {
+			svc, err := services.MakeInstance(ref.Type, ref.Name)
+			if err != nil {
+				continue
+			}
+			target := svc.Self()
+			if state != "" && target.StateName() != state {
+				continue
+			}
+			if selectEnabled && !target.State.Enabled {
+				continue
+			}
+			if !hasLabels(target.Config.Labels, labels) {
+				continue
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+func matchPattern(pattern string, value string) bool {
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+func hasLabels(labels map[string]string, wanted map[string]string) bool {
+	for key, value := range wanted {
+		if current, found := labels[key]; !found || current != value {
+			return false
+		}
+	}
+	return true
+}
+
+// mustSelectInstances selects instances, exiting when none matches
+func mustSelectInstances(args []string) []instance.InstanceRef {
+	refs, err := selectInstances(args)
+	if err != nil {
+		log.Println(err)
+		os.Exit(exitFailure)
+	}
+	if len(refs) == 0 {
+		log.Println("No instance matches the selection")
+		os.Exit(exitNotFound)
+	}
+	return refs
+}
+
+// selectsAll tells whether args select all instances with "all"
+func selectsAll(args []string) bool {
+	for _, arg := range args {
+		if arg == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// requireConfirm exits unless --confirm is set, for actions on all instances
+// or on more than confirmThreshold of them
+func requireConfirm(args []string, refs []instance.InstanceRef, action string) {
+	if confirm || (!selectsAll(args) && len(refs) <= confirmThreshold) {
+		return
+	}
+	fmt.Printf("--confirm is required when %s %d instances at once\n", action, len(refs))
+	os.Exit(exitFailure)
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/f4t/opsctl/instance"
+)
+
+// TestMain points opsctl to a home with a few disabled netprobe and logstash
+// instances. Labels are read from their rc files.
+func TestMain(m *testing.M) {
+	home, err := ioutil.TempDir("", "opsctl-test")
+	if err != nil {
+		panic(err)
+	}
+	opsctlHome := filepath.Join(home, "opsctl")
+	rcFiles := map[string]string{
+		"netprobe/prod-1": "NETPROBE_LISTEN_PORT=17001\nLABELS=team=payments,env=prod\n",
+		"netprobe/prod-2": "NETPROBE_LISTEN_PORT=17002\nLABELS=team=search,env=prod\n",
+		"netprobe/dev-1":  "NETPROBE_LISTEN_PORT=17003\nLABELS=team=payments,env=dev\n",
+		"logstash/prod-1": "LOGSTASH_HTTP_API_PORT=19600\nLABELS=team=payments,env=prod\n",
+	}
+	for ref, rc := range rcFiles {
+		workdir := filepath.Join(opsctlHome, "instances", ref)
+		if err := os.MkdirAll(workdir, 0755); err != nil {
+			panic(err)
+		}
+		rcFile := filepath.Join(workdir, filepath.Dir(ref)+".rc")
+		if err := ioutil.WriteFile(rcFile, []byte(rc), 0644); err != nil {
+			panic(err)
+		}
+	}
+	dotOpsctl := "OPSCTL_HOME=" + opsctlHome + "\n"
+	if err := ioutil.WriteFile(filepath.Join(home, ".opsctl"), []byte(dotOpsctl), 0644); err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	os.Unsetenv("OPSCTL_HOME")
+
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+func TestSelectInstances(t *testing.T) {
+	netprobeProd1 := instance.InstanceRef{Type: "netprobe", Name: "prod-1"}
+	netprobeProd2 := instance.InstanceRef{Type: "netprobe", Name: "prod-2"}
+	netprobeDev1 := instance.InstanceRef{Type: "netprobe", Name: "dev-1"}
+	logstashProd1 := instance.InstanceRef{Type: "logstash", Name: "prod-1"}
+
+	tests := []struct {
+		name       string
+		args       []string
+		selectType string
+		state      string
+		enabled    bool
+		labels     []string
+		want       []instance.InstanceRef
+		wantErr    bool
+	}{
+		{
+			name: "all",
+			args: []string{"all"},
+			want: []instance.InstanceRef{logstashProd1, netprobeDev1, netprobeProd1, netprobeProd2},
+		},
+		{
+			name: "single instance",
+			args: []string{"netprobe", "prod-1"},
+			want: []instance.InstanceRef{netprobeProd1},
+		},
+		{
+			name: "name glob",
+			args: []string{"netprobe/prod-*"},
+			want: []instance.InstanceRef{netprobeProd1, netprobeProd2},
+		},
+		{
+			name: "type glob",
+			args: []string{"*/prod-1"},
+			want: []instance.InstanceRef{logstashProd1, netprobeProd1},
+		},
+		{
+			name: "several patterns",
+			args: []string{"logstash/*", "netprobe/dev-?"},
+			want: []instance.InstanceRef{logstashProd1, netprobeDev1},
+		},
+		{
+			name:       "type filter without arguments",
+			selectType: "logstash",
+			want:       []instance.InstanceRef{logstashProd1},
+		},
+		{
+			name:       "type filter narrows patterns",
+			args:       []string{"*/prod-*"},
+			selectType: "netprobe",
+			want:       []instance.InstanceRef{netprobeProd1, netprobeProd2},
+		},
+		{
+			name:   "label",
+			args:   []string{"netprobe/*"},
+			labels: []string{"team=payments"},
+			want:   []instance.InstanceRef{netprobeDev1, netprobeProd1},
+		},
+		{
+			name:   "all labels required",
+			labels: []string{"team=payments", "env=prod"},
+			want:   []instance.InstanceRef{logstashProd1, netprobeProd1},
+		},
+		{
+			name:  "state",
+			args:  []string{"all"},
+			state: "disabled",
+			want:  []instance.InstanceRef{logstashProd1, netprobeDev1, netprobeProd1, netprobeProd2},
+		},
+		{
+			name:  "state matches none",
+			args:  []string{"all"},
+			state: "up",
+			want:  []instance.InstanceRef{},
+		},
+		{
+			name:    "enabled matches none",
+			args:    []string{"netprobe/*"},
+			enabled: true,
+			want:    []instance.InstanceRef{},
+		},
+		{
+			name:    "no selection",
+			wantErr: true,
+		},
+		{
+			name:    "invalid pattern",
+			args:    []string{"netprobe/[prod"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid reference",
+			args:    []string{"netprobe"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid state",
+			args:    []string{"all"},
+			state:   "sleeping",
+			wantErr: true,
+		},
+		{
+			name:    "invalid label",
+			args:    []string{"all"},
+			labels:  []string{"team"},
+			wantErr: true,
+		},
+	}
+	defer func() {
+		selectType, selectState, selectEnabled, selectLabels = "", "", false, nil
+	}()
+	for _, test := range tests {
+		selectType, selectState, selectEnabled, selectLabels = test.selectType, test.state, test.enabled, test.labels
+		got, err := selectInstances(test.args)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: selectInstances(%q) error = %v, wantErr %v", test.name, test.args, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: selectInstances(%q) = %v, want %v", test.name, test.args, got, test.want)
+		}
+	}
+}
+
+func TestHasLabels(t *testing.T) {
+	labels := map[string]string{"team": "payments", "env": "prod"}
+	tests := []struct {
+		wanted map[string]string
+		want   bool
+	}{
+		{map[string]string{}, true},
+		{map[string]string{"team": "payments"}, true},
+		{map[string]string{"team": "payments", "env": "prod"}, true},
+		{map[string]string{"team": "search"}, false},
+		{map[string]string{"team": "payments", "region": "eu"}, false},
+	}
+	for _, test := range tests {
+		if got := hasLabels(labels, test.wanted); got != test.want {
+			t.Errorf("hasLabels(%v, %v) = %v, want %v", labels, test.wanted, got, test.want)
+		}
+	}
+}
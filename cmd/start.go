@@ -12,7 +12,7 @@ import (
 
 // startCmd represents the start command
 var startCmd = &cobra.Command{
-	Use:   "start (<instance type> <instance name>|all --confirm)",
+	Use:   "start (<instance type> <instance name>|<selector>... [--confirm])",
 	Short: "Start service instances.",
 	Long: `Start service instances.
 Example:
@@ -20,50 +20,128 @@ Example:
 # Start a specific instance
 start <instance type> <instance name>
 
-# Start all instances at once
+# Start a specific instance, starting its dependencies first without asking
+start <instance type> <instance name> --with-deps
+
+# Start all instances at once, dependencies first (see DEPENDS_ON in rc files)
 start all --confirm
-`,
+
+# Start up to 4 independent instances at a time, 2s apart
+start all --confirm --parallel 4 --stagger 2s
+
+# Start the netprobe instances whose name starts with prod-
+start netprobe/prod-*
+
+# Start the instances of the payments team which are down
+start --state down -l team=payments --confirm
+
+# Show what starting all instances would do, without doing it
+start all --dry-run
+` + selectorHelp + exitCodesHelp,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) == 1 && args[0] == "all" {
-			if confirm {
-				doStartAllInstances()
-			} else {
-				fmt.Println("--confirm is required when starting all services at once")
-				os.Exit(1)
+		if dryRun && (len(args) > 0 || hasSelectorFilters()) {
+			printPlan("start", planLayers("start", args))
+		} else if !isSingleInstance(args) && (len(args) > 0 || hasSelectorFilters()) {
+			// Other selections only lock the instances they act on
+			if selectsAll(args) {
+				defer lockAll()()
 			}
+			refs := mustSelectInstances(args)
+			requireConfirm(args, refs, "starting")
+			printResults(doStartInstances(refs))
 		} else if len(args) == 2 {
 			instanceType := args[0]
 			instanceName := args[1]
-			doStartInstance(instanceType, instanceName)
+			doStartDependencies(instance.InstanceRef{Type: instanceType, Name: instanceName})
+			err := doStartInstance(instanceType, instanceName)
+			if err != nil {
+				os.Exit(exitCode(err))
+			}
 		} else {
 			cmd.Help()
 		}
 	},
 }
 
-func doStartAllInstances() {
-	for _, instanceType := range instance.DiscoverInstanceTypes() {
-		for _, instanceName := range instance.DiscoverInstances(instanceType) {
-			doStartInstance(instanceType, instanceName)
+// doStartInstances starts instances, dependencies first
+func doStartInstances(refs []instance.InstanceRef) []actionResult {
+	layers, err := services.DependencyOrderOf(refs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return runLayers(layers, "start", func(ref instance.InstanceRef) error {
+		return doStartInstance(ref.Type, ref.Name)
+	})
+}
+
+// Offers to start the dependencies of an instance which are not up
+func doStartDependencies(ref instance.InstanceRef) {
+	layers, err := services.DependenciesOf(ref)
+	if err != nil {
+		log.Println(err)
+		os.Exit(exitCode(err))
+	}
+	down := make([]instance.InstanceRef, 0)
+	for _, layer := range layers {
+		for _, dep := range layer {
+			svc, err := services.MakeInstance(dep.Type, dep.Name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !svc.Self().State.Up {
+				down = append(down, dep)
+			}
+		}
+	}
+	if len(down) == 0 {
+		return
+	}
+
+	if !withDeps {
+		question := fmt.Sprintf("%s depends on %v which are not running. Start them first?", ref, down)
+		if !askConfirmation(question) {
+			log.Printf("%s: not starting dependencies %v", ref, down)
+			return
+		}
+	}
+	for _, dep := range down {
+		err := doStartInstance(dep.Type, dep.Name)
+		if err != nil && !isSkipped(err) {
+			log.Fatalf("%s: dependency %s failed to start", ref, dep)
 		}
 	}
 }
 
-func doStartInstance(instanceType string, instanceName string) {
-	svc, err := services.MakeInstance(instanceType, instanceName)
+func doStartInstance(instanceType string, instanceName string) (err error) {
+	svc, release, err := makeLockedInstance(instanceType, instanceName)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer release()
 	instance := svc.Self()
+	defer func() { journalAction("start", instance, err) }()
 	instance.LogMsg("attempting start")
-	err = instance.Preflight() // Exit if anything wrong.
+	if instance.State.Exists && !instance.State.Enabled {
+		instance.LogMsg("Instance is not enabled")
+		return skippedDisabled
+	}
+	err = services.Preflight(svc)
 	if err != nil {
-		return
+		return err
+	}
+	err = svc.Start()
+	if err != nil {
+		return err
 	}
-	svc.Start()
+	return checkInstanceState(instanceType, instanceName, true)
 }
 
 func init() {
 	rootCmd.AddCommand(startCmd)
 	startCmd.Flags().BoolVar(&confirm, "confirm", false, "Confirm flag is only required when starting all services at once.")
+	startCmd.Flags().BoolVar(&withDeps, "with-deps", false, "Start the dependencies of the instance without asking.")
+	startCmd.Flags().IntVar(&parallel, "parallel", 1, "Number of instances started at once with 'all'.")
+	startCmd.Flags().DurationVar(&stagger, "stagger", 0, "Delay between starting two instances with 'all'.")
+	addSelectorFlags(startCmd)
+	startCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be done, without touching any process.")
 }
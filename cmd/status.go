@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"log"
 	"os"
 
 	"github.com/f4t/opsctl/instance"
@@ -11,7 +12,7 @@ import (
 
 // statusCmd represents the status command
 var statusCmd = &cobra.Command{
-	Use:   "status [<instance type> <instance name>]",
+	Use:   "status [<instance type> <instance name>|<selector>...]",
 	Short: "Show instances status.",
 	Long: `Show instances status.
 
@@ -20,49 +21,69 @@ opsctl status
 
 # Show detailed summary of a specific instance:
 opsctl status <instance type> <instance name>
-`,
+
+# Show all instances as JSON, for scripts:
+opsctl status -o json
+
+# Show the netprobe instances which are down:
+opsctl status netprobe/* --state down
+
+Values exported to instances through ENV_ variables are redacted, unless
+--show-env is set.
+` + selectorHelp,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) == 0 || (len(args) == 1 && args[0] == "all") {
-			statusAll()
-		} else if len(args) == 2 {
+		if isSingleInstance(args) {
 			instanceStatus(args[0], args[1])
+		} else if len(args) == 0 && !hasSelectorFilters() {
+			statusAll(instance.DiscoverAllInstances())
 		} else {
-			cmd.Help()
+			statusAll(mustSelectInstances(args))
 		}
 	},
 }
 
 func instanceStatus(instanceType string, instanceName string) {
-	svc, _ := services.MakeInstance(instanceType, instanceName)
+	svc, err := services.MakeInstance(instanceType, instanceName)
+	if err != nil {
+		log.Fatal(err)
+	}
 	instance := svc.Self()
+	if isMachineOutput() {
+		renderReports([]services.ServiceInterface{svc}, nil)
+		return
+	}
 	if instance.State.Exists {
-		instance.PrintSummary()
+		instance.PrintSummary(showEnv)
 	} else {
 		instance.LogMsg("does not exist")
 	}
 
 }
 
-func statusAll() {
+func statusAll(refs []instance.InstanceRef) {
 	instances := make([]services.ServiceInterface, 0)
-	for _, instanceType := range instance.DiscoverInstanceTypes() {
-		for _, instanceName := range instance.DiscoverInstances(instanceType) {
-			svc, err := services.MakeInstance(instanceType, instanceName)
-			if err != nil {
-				continue
-			}
-			instances = append(instances, svc)
+	for _, ref := range refs {
+		svc, err := services.MakeInstance(ref.Type, ref.Name)
+		if err != nil {
+			continue
 		}
+		instances = append(instances, svc)
+	}
+
+	if isMachineOutput() {
+		renderReports(instances, nil)
+		return
 	}
 
 	tableData := make([][]string, 0)
 	for _, instance := range instances {
 		row := instance.Self().StatusRow()
 		tableData = append(tableData, row)
+		tableData = append(tableData, instance.Self().SidecarStatusRows()...)
 	}
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Type", "Name", "Enabled", "State", "PID", "Errors"})
+	table.SetHeader([]string{"Type", "Name", "Enabled", "State", "Health", "PID", "User", "Ports", "Errors"})
 	for _, v := range tableData {
 		table.Append(v)
 	}
@@ -71,4 +92,6 @@ func statusAll() {
 
 func init() {
 	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().BoolVar(&showEnv, "show-env", false, "Show the values exported to instances through ENV_ variables.")
+	addSelectorFlags(statusCmd)
 }
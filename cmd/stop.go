@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"fmt"
 	"log"
 	"os"
 
@@ -12,7 +11,7 @@ import (
 
 // stopCmd represents the stop command
 var stopCmd = &cobra.Command{
-	Use:   "stop (<instance type> <instance name>|all --confirm)",
+	Use:   "stop (<instance type> <instance name>|<selector>... [--confirm])",
 	Short: "Stop service instances.",
 	Long: `Stop service instances.
 Example:
@@ -20,47 +19,78 @@ Example:
 # Stop a specific instance
 stop <instance type> <instance name>
 
-# Stop all instances at once
+# Stop all instances at once, dependents first (see DEPENDS_ON in rc files)
 stop all --confirm
-`,
+
+# Stop up to 4 independent instances at a time
+stop all --confirm --parallel 4
+
+# Stop all logstash instances
+stop --type logstash --confirm
+
+# Show what stopping all instances would do, without doing it
+stop all --dry-run
+` + selectorHelp + exitCodesHelp,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) == 1 && args[0] == "all" {
-			if confirm {
-				doStopAllInstances()
-			} else {
-				fmt.Println("--confirm is required when stopping all services at once")
-				os.Exit(1)
+		if dryRun && (len(args) > 0 || hasSelectorFilters()) {
+			printPlan("stop", planLayers("stop", args))
+		} else if !isSingleInstance(args) && (len(args) > 0 || hasSelectorFilters()) {
+			// Other selections only lock the instances they act on
+			if selectsAll(args) {
+				defer lockAll()()
 			}
+			refs := mustSelectInstances(args)
+			requireConfirm(args, refs, "stopping")
+			printResults(doStopInstances(refs))
 		} else if len(args) == 2 {
 			instanceType := args[0]
 			instanceName := args[1]
-			doStopInstance(instanceType, instanceName)
+			err := doStopInstance(instanceType, instanceName)
+			if err != nil {
+				os.Exit(exitCode(err))
+			}
 		} else {
 			cmd.Help()
 		}
 	},
 }
 
-func doStopAllInstances() {
-	for _, instanceType := range instance.DiscoverInstanceTypes() {
-		for _, instanceName := range instance.DiscoverInstances(instanceType) {
-			doStopInstance(instanceType, instanceName)
-		}
+// doStopInstances stops instances, dependents first
+func doStopInstances(refs []instance.InstanceRef) []actionResult {
+	layers, err := services.DependencyOrderOf(refs)
+	if err != nil {
+		log.Fatal(err)
 	}
+	return runLayers(instance.ReverseLayers(layers), "stop", func(ref instance.InstanceRef) error {
+		return doStopInstance(ref.Type, ref.Name)
+	})
 }
 
-func doStopInstance(instanceType string, instanceName string) {
-	svc, err := services.MakeInstance(instanceType, instanceName)
+func doStopInstance(instanceType string, instanceName string) (err error) {
+	svc, release, err := makeLockedInstance(instanceType, instanceName)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer release()
 	instance := svc.Self()
+	defer func() { journalAction("stop", instance, err) }()
 	instance.LogMsg("attempting stop")
-	instance.Preflight() // Exit if anything wrong.
-	svc.Stop()
+	if !instance.State.Exists {
+		instance.LogMsg(instance.Errors.Exists.Error())
+		return instance.Errors.Exists
+	}
+	err = svc.Stop()
+	if err != nil {
+		return err
+	}
+	return checkInstanceState(instanceType, instanceName, false)
 }
 
 func init() {
 	rootCmd.AddCommand(stopCmd)
 	stopCmd.Flags().BoolVar(&confirm, "confirm", false, "Confirm flag is only required when stopping all services at once.")
+	stopCmd.Flags().IntVar(&parallel, "parallel", 1, "Number of instances stopped at once with 'all'.")
+	stopCmd.Flags().DurationVar(&stagger, "stagger", 0, "Delay between stopping two instances with 'all'.")
+	addSelectorFlags(stopCmd)
+	stopCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be done, without touching any process.")
 }
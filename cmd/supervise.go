@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/services"
+	"github.com/f4t/opsctl/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	superviseInterval      time.Duration
+	superviseBackoffMin    time.Duration
+	superviseBackoffMax    time.Duration
+	superviseMaxRestarts   int
+	superviseRestartWindow time.Duration
+)
+
+// superviseCmd represents the supervise command
+var superviseCmd = &cobra.Command{
+	Use:   "supervise",
+	Short: "Watch enabled instances and restart them when they crash.",
+	Long: `Watch enabled instances and restart them when they crash.
+
+An instance is considered crashed when its pid file is left behind by a process
+which is gone. Instances stopped through opsctl and disabled instances are left alone.
+Restarts are delayed with an exponential backoff, and an instance is given up
+on once it has been restarted --max-restarts times within --restart-window.
+
+Stopping the supervisor (SIGTERM or SIGINT) leaves running instances untouched.
+Example:
+
+# Supervise all instances
+opsctl supervise
+
+# Check every 30s, restart at most 3 times per hour
+opsctl supervise --interval 30s --max-restarts 3 --restart-window 1h
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		supervise()
+	},
+}
+
+// restartTracker holds the restart history of a supervised instance
+type restartTracker struct {
+	failures    int
+	nextAttempt time.Time
+	restarts    []time.Time
+	givenUp     bool
+}
+
+func supervise() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	trackers := make(map[string]*restartTracker)
+	ticker := time.NewTicker(superviseInterval)
+	defer ticker.Stop()
+
+	log.Printf("Supervising instances every %s", superviseInterval)
+	for {
+		superviseOnce(trackers)
+		select {
+		case sig := <-signals:
+			log.Printf("Received %s, supervisor exiting. Instances are left running.", sig)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func superviseOnce(trackers map[string]*restartTracker) {
+	utils.RefreshProcTable()
+	// A transient error only skips this round, the supervisor keeps running
+	instanceTypes, err := instance.ListInstanceTypes()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, instanceType := range instanceTypes {
+		instanceNames, err := instance.ListInstances(instanceType)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, instanceName := range instanceNames {
+			key := fmt.Sprintf("%s/%s", instanceType, instanceName)
+			svc, err := services.MakeInstance(instanceType, instanceName)
+			if err != nil {
+				continue
+			}
+			instance := svc.Self()
+			if !instance.State.Exists || !instance.State.Enabled || instance.Errors.Config != nil {
+				delete(trackers, key)
+				continue
+			}
+
+			tracker, found := trackers[key]
+			if !found {
+				tracker = &restartTracker{}
+				trackers[key] = tracker
+			}
+
+			if instance.State.Up {
+				// Forget about past failures once the instance has been stable
+				if tracker.failures > 0 && time.Since(lastRestart(tracker)) > superviseBackoffMax {
+					tracker.failures = 0
+				}
+				continue
+			}
+
+			if !instance.Crashed() {
+				continue
+			}
+
+			now := time.Now()
+			if now.Before(tracker.nextAttempt) {
+				continue
+			}
+
+			// Only keep restarts within the window
+			restarts := make([]time.Time, 0)
+			for _, t := range tracker.restarts {
+				if now.Sub(t) < superviseRestartWindow {
+					restarts = append(restarts, t)
+				}
+			}
+			tracker.restarts = restarts
+			if len(tracker.restarts) >= superviseMaxRestarts {
+				if !tracker.givenUp {
+					instance.LogMsg(fmt.Sprintf("restarted %d times within %s, giving up", len(tracker.restarts), superviseRestartWindow))
+					tracker.givenUp = true
+				}
+				continue
+			}
+			tracker.givenUp = false
+
+			// Leave the instance to another opsctl run working on it
+			release, err := instance.Lock(0)
+			if err != nil {
+				continue
+			}
+			utils.RefreshProcTable()
+			if up, _ := instance.IsUp(); up || !instance.Crashed() {
+				release()
+				continue
+			}
+
+			instance.LogMsg(fmt.Sprintf("crashed, restarting (attempt %d)", tracker.failures+1))
+			journalCrash(instance)
+			startErr := svc.Start()
+			utils.RefreshProcTable()
+			if up, _ := instance.IsUp(); !up && startErr == nil {
+				startErr = errors.New("Instance is not running after start")
+			}
+			journalAction("start", instance, startErr)
+			release()
+
+			tracker.restarts = append(tracker.restarts, now)
+			tracker.nextAttempt = now.Add(backoffDelay(tracker.failures))
+			tracker.failures++
+		}
+	}
+}
+
+// journalCrash records the process of the pid file found dead
+func journalCrash(crashed instance.Instance) {
+	entry := instance.JournalEntry{Action: "crash", Outcome: instance.OutcomeOK}
+	if pidFile, err := utils.ReadPidFile(crashed.PidFilePath()); err == nil {
+		entry.PIDBefore = pidFile.Pid
+	}
+	if err := crashed.Journal(entry); err != nil {
+		crashed.LogMsg(fmt.Sprintf("Failed writing the audit journal: %s", err))
+	}
+}
+
+func lastRestart(tracker *restartTracker) time.Time {
+	if len(tracker.restarts) == 0 {
+		return time.Time{}
+	}
+	return tracker.restarts[len(tracker.restarts)-1]
+}
+
+// Exponential backoff: min, 2*min, 4*min, ... capped at max
+func backoffDelay(failures int) time.Duration {
+	delay := superviseBackoffMin
+	for i := 0; i < failures && delay < superviseBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > superviseBackoffMax {
+		delay = superviseBackoffMax
+	}
+	return delay
+}
+
+func init() {
+	rootCmd.AddCommand(superviseCmd)
+	superviseCmd.Flags().DurationVar(&superviseInterval, "interval", 10*time.Second, "Delay between two checks of all instances.")
+	superviseCmd.Flags().DurationVar(&superviseBackoffMin, "backoff-min", 5*time.Second, "Delay before retrying after the first restart.")
+	superviseCmd.Flags().DurationVar(&superviseBackoffMax, "backoff-max", 5*time.Minute, "Maximum delay between two restarts of an instance.")
+	superviseCmd.Flags().IntVar(&superviseMaxRestarts, "max-restarts", 5, "Maximum number of restarts of an instance within --restart-window.")
+	superviseCmd.Flags().DurationVar(&superviseRestartWindow, "restart-window", 30*time.Minute, "Sliding window over which --max-restarts applies.")
+}
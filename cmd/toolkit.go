@@ -14,24 +14,26 @@ import (
 
 // toolkitCmd represents the toolkit command
 var toolkitCmd = &cobra.Command{
-	Use:   "toolkit",
+	Use:   "toolkit [<selector>...]",
 	Short: "toolkit shows services status in CSV format for ITRS",
-	Long:  `toolkit shows services status in CSV format for ITRS`,
+	Long:  `toolkit shows services status in CSV format for ITRS` + "\n" + selectorHelp,
 	Run: func(cmd *cobra.Command, args []string) {
-		toolkitAll()
+		if len(args) == 0 && !hasSelectorFilters() {
+			toolkitAll(instance.DiscoverAllInstances())
+		} else {
+			toolkitAll(mustSelectInstances(args))
+		}
 	},
 }
 
-func toolkitAll() {
+func toolkitAll(refs []instance.InstanceRef) {
 	instances := make([]services.ServiceInterface, 0)
-	for _, instanceType := range instance.DiscoverInstanceTypes() {
-		for _, instanceName := range instance.DiscoverInstances(instanceType) {
-			svc, err := services.MakeInstance(instanceType, instanceName)
-			if err != nil {
-				continue
-			}
-			instances = append(instances, svc)
+	for _, ref := range refs {
+		svc, err := services.MakeInstance(ref.Type, ref.Name)
+		if err != nil {
+			continue
 		}
+		instances = append(instances, svc)
 	}
 
 	opsctlEnv := utils.LoadOpsctlEnv()
@@ -48,6 +50,11 @@ func toolkitAll() {
 	headlines["archived_logs"] = archivedLogsDirSizeStr
 	headlines["services_home"] = opsctlEnv.Home
 
+	if isMachineOutput() {
+		renderReports(instances, headlines)
+		return
+	}
+
 	for k, v := range headlines {
 		fmt.Printf("<!>%s,%s\n", k, v)
 	}
@@ -56,10 +63,11 @@ func toolkitAll() {
 	for _, instance := range instances {
 		row := instance.Self().ToolkitRow()
 		toolkitRows = append(toolkitRows, row)
+		toolkitRows = append(toolkitRows, instance.Self().SidecarToolkitRows()...)
 	}
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"instance", "status", "port", "type", "name", "start_time", "uptime_hours", "pid", "threads", "dir_size", "data_size", "cmdline"})
+	table.SetHeader([]string{"instance", "status", "health", "port", "type", "name", "start_time", "uptime_hours", "pid", "threads", "dir_size", "data_size", "cmdline"})
 	for _, v := range toolkitRows {
 		table.Append(v)
 	}
@@ -68,4 +76,5 @@ func toolkitAll() {
 
 func init() {
 	rootCmd.AddCommand(toolkitCmd)
+	addSelectorFlags(toolkitCmd)
 }
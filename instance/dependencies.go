@@ -0,0 +1,172 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// InstanceRef identifies an instance by type and name
+type InstanceRef struct {
+	Type string
+	Name string
+}
+
+func (ref InstanceRef) String() string {
+	return fmt.Sprintf("%s/%s", ref.Type, ref.Name)
+}
+
+// CheckPath rejects types and names which are not a single directory name,
+// e.g. "", ".", ".." or "a/b", before they are used in paths
+func (ref InstanceRef) CheckPath() error {
+	for _, value := range []string{ref.Type, ref.Name} {
+		if value == "" || value == "." || value == ".." || strings.ContainsAny(value, "/\x00") {
+			errMsg := fmt.Sprintf("Invalid instance type or name '%s'", value)
+			return errors.New(errMsg)
+		}
+	}
+	return nil
+}
+
+// Types and names of new instances are kept to portable directory names
+var validRefName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Validate checks the type and name of a new instance. Existing instances
+// only need to pass CheckPath.
+func (ref InstanceRef) Validate() error {
+	err := ref.CheckPath()
+	if err != nil {
+		return err
+	}
+	for _, value := range []string{ref.Type, ref.Name} {
+		if !validRefName.MatchString(value) {
+			errMsg := fmt.Sprintf("Invalid instance type or name '%s', only letters, digits, '_', '.' and '-' are allowed", value)
+			return errors.New(errMsg)
+		}
+	}
+	return nil
+}
+
+func (instance Instance) Ref() InstanceRef {
+	return InstanceRef{Type: instance.Config.Type, Name: instance.Config.Name}
+}
+
+// ParseInstanceRef parses a "<type>/<name>" reference
+func ParseInstanceRef(value string) (InstanceRef, error) {
+	parts := strings.Split(strings.TrimSpace(value), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		errMsg := fmt.Sprintf("Invalid instance reference '%s', expected <type>/<name>", value)
+		return InstanceRef{}, errors.New(errMsg)
+	}
+	return InstanceRef{Type: parts[0], Name: parts[1]}, nil
+}
+
+// ParseDependsOn parses a DEPENDS_ON value: a comma separated list of <type>/<name>
+func ParseDependsOn(value string) ([]InstanceRef, error) {
+	refs := make([]InstanceRef, 0)
+	for _, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		ref, err := ParseInstanceRef(item)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+func DiscoverAllInstances() []InstanceRef {
+	refs, err := ListAllInstances()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return refs
+}
+
+// ListAllInstances works as DiscoverAllInstances, returning errors instead of
+// exiting, for long-running callers
+func ListAllInstances() ([]InstanceRef, error) {
+	instanceTypes, err := ListInstanceTypes()
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]InstanceRef, 0)
+	for _, instanceType := range instanceTypes {
+		instanceNames, err := ListInstances(instanceType)
+		if err != nil {
+			return nil, err
+		}
+		for _, instanceName := range instanceNames {
+			refs = append(refs, InstanceRef{Type: instanceType, Name: instanceName})
+		}
+	}
+	return refs, nil
+}
+
+// DependencyLayers sorts refs topologically. Each layer only contains instances
+// whose dependencies are all in previous layers, so instances of a same layer
+// are independent from each other. Dependencies outside of refs are ignored.
+func DependencyLayers(refs []InstanceRef, dependencies map[InstanceRef][]InstanceRef) ([][]InstanceRef, error) {
+	known := make(map[InstanceRef]bool)
+	for _, ref := range refs {
+		known[ref] = true
+	}
+
+	// Count unsatisfied dependencies of each instance
+	pending := make(map[InstanceRef]int)
+	dependents := make(map[InstanceRef][]InstanceRef)
+	for _, ref := range refs {
+		pending[ref] = 0
+		for _, dep := range dependencies[ref] {
+			if !known[dep] {
+				continue
+			}
+			pending[ref]++
+			dependents[dep] = append(dependents[dep], ref)
+		}
+	}
+
+	layers := make([][]InstanceRef, 0)
+	done := 0
+	for done < len(refs) {
+		layer := make([]InstanceRef, 0)
+		for _, ref := range refs {
+			if count, found := pending[ref]; found && count == 0 {
+				layer = append(layer, ref)
+			}
+		}
+		if len(layer) == 0 {
+			// Whatever is left is part of, or depends on, a cycle
+			cycle := make([]string, 0)
+			for ref := range pending {
+				cycle = append(cycle, ref.String())
+			}
+			sort.Strings(cycle)
+			errMsg := fmt.Sprintf("Dependency cycle between instances: %s", strings.Join(cycle, ", "))
+			return nil, errors.New(errMsg)
+		}
+		for _, ref := range layer {
+			delete(pending, ref)
+			for _, dependent := range dependents[ref] {
+				pending[dependent]--
+			}
+		}
+		layers = append(layers, layer)
+		done += len(layer)
+	}
+	return layers, nil
+}
+
+// ReverseLayers returns layers in reverse order, e.g. to stop dependents first
+func ReverseLayers(layers [][]InstanceRef) [][]InstanceRef {
+	reversed := make([][]InstanceRef, 0, len(layers))
+	for i := len(layers) - 1; i >= 0; i-- {
+		reversed = append(reversed, layers[i])
+	}
+	return reversed
+}
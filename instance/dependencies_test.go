@@ -0,0 +1,135 @@
+package instance
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDependsOn(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []InstanceRef
+		wantErr bool
+	}{
+		{"", []InstanceRef{}, false},
+		{"netprobe/np1", []InstanceRef{{"netprobe", "np1"}}, false},
+		{" netprobe/np1, logstash/ls1 ,", []InstanceRef{{"netprobe", "np1"}, {"logstash", "ls1"}}, false},
+		{"netprobe", nil, true},
+		{"netprobe/", nil, true},
+		{"/np1", nil, true},
+		{"netprobe/np1/x", nil, true},
+	}
+	for _, test := range tests {
+		got, err := ParseDependsOn(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseDependsOn(%q) error = %v, wantErr %v", test.value, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseDependsOn(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestDependencyLayers(t *testing.T) {
+	a := InstanceRef{"netprobe", "a"}
+	b := InstanceRef{"netprobe", "b"}
+	c := InstanceRef{"logstash", "c"}
+	d := InstanceRef{"logstash", "d"}
+	outside := InstanceRef{"node_exporter", "outside"}
+
+	tests := []struct {
+		name         string
+		refs         []InstanceRef
+		dependencies map[InstanceRef][]InstanceRef
+		want         [][]InstanceRef
+		wantErr      bool
+	}{
+		{
+			name: "no refs",
+			refs: []InstanceRef{},
+			want: [][]InstanceRef{},
+		},
+		{
+			name: "independent instances in one layer, in refs order",
+			refs: []InstanceRef{b, a, c},
+			want: [][]InstanceRef{{b, a, c}},
+		},
+		{
+			name:         "chain",
+			refs:         []InstanceRef{c, b, a},
+			dependencies: map[InstanceRef][]InstanceRef{c: {b}, b: {a}},
+			want:         [][]InstanceRef{{a}, {b}, {c}},
+		},
+		{
+			name:         "diamond",
+			refs:         []InstanceRef{a, b, c, d},
+			dependencies: map[InstanceRef][]InstanceRef{b: {a}, c: {a}, d: {b, c}},
+			want:         [][]InstanceRef{{a}, {b, c}, {d}},
+		},
+		{
+			name:         "dependencies outside of refs are ignored",
+			refs:         []InstanceRef{a, b},
+			dependencies: map[InstanceRef][]InstanceRef{a: {outside}, b: {a, outside}},
+			want:         [][]InstanceRef{{a}, {b}},
+		},
+		{
+			name:         "cycle",
+			refs:         []InstanceRef{a, b, c},
+			dependencies: map[InstanceRef][]InstanceRef{a: {b}, b: {a}},
+			wantErr:      true,
+		},
+		{
+			name:         "self dependency",
+			refs:         []InstanceRef{a},
+			dependencies: map[InstanceRef][]InstanceRef{a: {a}},
+			wantErr:      true,
+		},
+	}
+	for _, test := range tests {
+		got, err := DependencyLayers(test.refs, test.dependencies)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReverseLayers(t *testing.T) {
+	a := InstanceRef{"netprobe", "a"}
+	b := InstanceRef{"netprobe", "b"}
+	c := InstanceRef{"logstash", "c"}
+	got := ReverseLayers([][]InstanceRef{{a}, {b, c}})
+	want := [][]InstanceRef{{b, c}, {a}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReverseLayers() = %v, want %v", got, want)
+	}
+}
+
+func TestInstanceRefChecks(t *testing.T) {
+	tests := []struct {
+		ref          InstanceRef
+		checkPathErr bool
+		validateErr  bool
+	}{
+		{InstanceRef{"netprobe", "np1"}, false, false},
+		{InstanceRef{"netprobe", "np-1.eu_west"}, false, false},
+		{InstanceRef{"netprobe", "my probe"}, false, true},
+		{InstanceRef{"netprobe", ""}, true, true},
+		{InstanceRef{"netprobe", "."}, true, true},
+		{InstanceRef{"netprobe", ".."}, true, true},
+		{InstanceRef{"..", "np1"}, true, true},
+		{InstanceRef{"netprobe", "a/b"}, true, true},
+	}
+	for _, test := range tests {
+		if err := test.ref.CheckPath(); (err != nil) != test.checkPathErr {
+			t.Errorf("%v.CheckPath() error = %v, wantErr %v", test.ref, err, test.checkPathErr)
+		}
+		if err := test.ref.Validate(); (err != nil) != test.validateErr {
+			t.Errorf("%v.Validate() error = %v, wantErr %v", test.ref, err, test.validateErr)
+		}
+	}
+}
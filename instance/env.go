@@ -0,0 +1,121 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+const (
+	// Rc variables prefixed with ENV_ are exported to the process without
+	// the prefix, e.g. ENV_JAVA_HOME=/opt/java exports JAVA_HOME
+	envPrefix    = "ENV_"
+	extraArgsVar = "EXTRA_ARGS"
+)
+
+// Values exported through ENV_ are often secrets, e.g. passwords: status and
+// reports only show them when asked to
+const redactedValue = "REDACTED"
+
+// Variables passed on from the opsctl environment, everything else is left out
+var inheritedEnvVars = []string{
+	"PATH",
+	"LANG",
+	"LC_ALL",
+	"TZ",
+}
+
+// loadEnv returns the variables exported by the rc file through ENV_
+func loadEnv(vars map[string]string) map[string]string {
+	env := make(map[string]string)
+	for k, v := range vars {
+		if strings.HasPrefix(k, envPrefix) && len(k) > len(envPrefix) {
+			env[strings.TrimPrefix(k, envPrefix)] = v
+		}
+	}
+	return env
+}
+
+// loadExtraArgs parses EXTRA_ARGS, split like a shell would
+func loadExtraArgs(vars map[string]string) ([]string, error) {
+	args, err := utils.SplitArgs(vars[extraArgsVar])
+	if err != nil {
+		errMsg := fmt.Sprintf("Invalid %s: %s", extraArgsVar, err)
+		return nil, errors.New(errMsg)
+	}
+	return args, nil
+}
+
+// ChildEnv returns the environment of the instance process: PATH, LANG,
+// LC_ALL and TZ from opsctl, HOME, USER and LOGNAME of the user it runs as,
+// then variables exported by the rc file.
+func (instance Instance) ChildEnv() map[string]string {
+	env := make(map[string]string)
+	for _, k := range inheritedEnvVars {
+		if v, found := os.LookupEnv(k); found {
+			env[k] = v
+		}
+	}
+	if _, found := env["PATH"]; !found {
+		env["PATH"] = "/usr/local/bin:/usr/bin:/bin"
+	}
+
+	var u *user.User
+	var err error
+	if instance.Config.Credential != nil {
+		u, err = user.LookupId(strconv.FormatUint(uint64(instance.Config.Credential.Uid), 10))
+	} else {
+		u, err = user.Current()
+	}
+	if err == nil {
+		env["HOME"] = u.HomeDir
+		env["USER"] = u.Username
+		env["LOGNAME"] = u.Username
+	}
+
+	for k, v := range instance.Config.Env {
+		env[k] = v
+	}
+	return env
+}
+
+// ReportedEnv returns ChildEnv, with the values exported by the rc file
+// redacted unless showValues is set
+func (instance Instance) ReportedEnv(showValues bool) map[string]string {
+	env := instance.ChildEnv()
+	if !showValues {
+		for k := range instance.Config.Env {
+			env[k] = redactedValue
+		}
+	}
+	return env
+}
+
+// ReportedRcValues returns RcValues, with the ENV_ values redacted unless
+// showValues is set
+func (instance Instance) ReportedRcValues(showValues bool) map[string]string {
+	values := make(map[string]string)
+	for k, v := range instance.Config.RcValues {
+		if !showValues && strings.HasPrefix(k, envPrefix) {
+			v = redactedValue
+		}
+		values[k] = v
+	}
+	return values
+}
+
+// childEnvList formats env as KEY=value entries, sorted
+func childEnvList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for k, v := range env {
+		list = append(list, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(list)
+	return list
+}
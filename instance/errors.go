@@ -0,0 +1,54 @@
+package instance
+
+import (
+	"errors"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+// ErrorKind tells what went wrong with an instance, for callers to act on
+type ErrorKind string
+
+const (
+	KindNotFound     ErrorKind = "not_found"
+	KindDisabled     ErrorKind = "disabled"
+	KindBadConfig    ErrorKind = "bad_config"
+	KindStartTimeout ErrorKind = "start_timeout"
+	KindKillFailed   ErrorKind = "kill_failed"
+	KindLocked       ErrorKind = "locked"
+)
+
+// Error is an instance error of a known kind
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (err Error) Error() string {
+	return err.Err.Error()
+}
+
+func (err Error) Unwrap() error {
+	return err.Err
+}
+
+// NewError gives a kind to err, nil stays nil
+func NewError(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return Error{Kind: kind, Err: err}
+}
+
+// KindOf returns the kind of err, empty when unknown
+func KindOf(err error) ErrorKind {
+	var instanceErr Error
+	if errors.As(err, &instanceErr) {
+		return instanceErr.Kind
+	}
+	var lockErr utils.LockHeldError
+	if errors.As(err, &lockErr) {
+		return KindLocked
+	}
+	return ""
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -27,10 +28,31 @@ type InstanceConfig struct {
 	Name            string   // Generic
 	Workdir         string   // Generic
 	MandatoryRcVars []string // Specific
+	OptionalRcVars  []string // Specific, besides the generic optional ones
 	RcValues        map[string]string
 	PackageVer      string   // Specific
 	StartupArgs     []string // Specific
 	RuntimeArgs     []string // Specific
+	Dependencies    []InstanceRef
+	Labels          map[string]string
+	Layout          WorkdirLayout // Specific
+	LogFiles        []string      // Specific, logs written by the service itself
+	PortRcVars      []string      // Specific, rc variables holding ports
+	Limits          utils.ProcessLimits
+	Credential      *syscall.Credential // User the process runs as, nil for the current one
+	Env             map[string]string   // Exported to the process besides a clean base environment
+	ExtraArgs       []string            // Appended to StartupArgs
+	Sidecars        []Sidecar           // Declared by the package or the rc file
+	// Readiness probes must pass before a start is reported successful,
+	// liveness probes tell whether a running instance is healthy.
+	ReadinessProbes  []utils.Probe // Specific
+	LivenessProbes   []utils.Probe // Specific
+	ReadinessTimeout time.Duration // Specific
+	// Wait for the process to appear after start, then to exit after
+	// SIGTERM and after SIGKILL
+	StartupGracePeriod time.Duration // Specific
+	SigtermGracePeriod time.Duration // Specific
+	SigkillGracePeriod time.Duration // Specific
 }
 
 type InstanceState struct {
@@ -63,7 +85,7 @@ func MakeGenericInstance(Type string, Name string) Instance {
 	// Check if workdir exists
 	exists, err := instance.WorkdirExists()
 	instance.State.Exists = exists
-	instance.Errors.Exists = err
+	instance.Errors.Exists = NewError(KindNotFound, err)
 
 	if exists {
 		// Check if instance is enabled
@@ -105,8 +127,7 @@ func (instance Instance) WorkdirExists() (bool, error) {
 }
 
 func (instance Instance) isEnabled() (bool, error) {
-	enabledFlag := filepath.Join(instance.Config.Workdir, "enabled.flag")
-	stat, err := os.Stat(enabledFlag)
+	stat, err := os.Stat(instance.enabledFlagPath())
 	if err != nil {
 		errMsg := fmt.Sprintf("enabled.flag not found at %s. Please enable instance.", instance.Config.Workdir)
 		return false, errors.New(errMsg)
@@ -120,6 +141,19 @@ func (instance Instance) isEnabled() (bool, error) {
 }
 
 func (instance Instance) IsUp() (bool, int) {
+	// The pid file written at startup is authoritative when present
+	pidFile, err := utils.ReadPidFile(instance.PidFilePath())
+	if err == nil {
+		if pidFile.IsAlive() {
+			return true, pidFile.Pid
+		}
+		return false, -1
+	}
+	if !os.IsNotExist(err) {
+		instance.LogMsg(err.Error())
+	}
+
+	// Fallback for processes started before pid files were written
 	pids, _ := utils.GetMatchingPids(instance.Config.RuntimeArgs)
 	if len(pids) == 1 {
 		return true, pids[0]
@@ -127,23 +161,59 @@ func (instance Instance) IsUp() (bool, int) {
 	return false, -1
 }
 
-const packageVersionVar = "INSTANCE_PACKAGE_VERSION"
-
-func (instance *Instance) LoadRcConfig() error {
-	// Unset variables if already present
-	// Necessary as godotenv won't override on its own
-	os.Unsetenv(packageVersionVar)
-	for _, v := range instance.Config.MandatoryRcVars {
-		os.Unsetenv(v)
+// Crashed returns true if the process recorded in the pid file is gone.
+// A clean stop through opsctl removes the pid file.
+func (instance Instance) Crashed() bool {
+	pidFile, err := utils.ReadPidFile(instance.PidFilePath())
+	if err != nil {
+		return false
 	}
+	return !pidFile.IsAlive()
+}
 
-	// Source rc file for instance
-	rcFile := filepath.Join(
+func (instance Instance) PidFilePath() string {
+	return filepath.Join(
 		instance.Config.Workdir,
-		fmt.Sprintf("%s.rc", instance.Config.Type),
+		fmt.Sprintf("%s.pid", instance.Config.Type),
 	)
+}
+
+const (
+	packageVersionVar    = "INSTANCE_PACKAGE_VERSION"
+	dependsOnVar         = "DEPENDS_ON"
+	probeTimeoutVar      = "PROBE_TIMEOUT"
+	readinessTimeoutVar  = "READINESS_TIMEOUT"
+	defaultProbeTimeout  = 2 * time.Second
+	readinessPollingTime = 500 * time.Millisecond
+)
+
+// Optional variables are loaded into RcValues when set in the rc file
+var optionalRcVars = append([]string{
+	dependsOnVar,
+	probeTimeoutVar,
+	readinessTimeoutVar,
+	logMaxSizeVar,
+	logMaxAgeVar,
+	logKeepVar,
+	logMaxTotalSizeVar,
+	runAsUserVar,
+	runAsGroupVar,
+	extraArgsVar,
+	labelsVar,
+	sidecarsVar,
+}, utils.LimitRcVars...)
+
+// LoadRcConfig loads the rc file, its errors are of kind KindBadConfig
+func (instance *Instance) LoadRcConfig() error {
+	return NewError(KindBadConfig, instance.loadRcConfig())
+}
 
-	err := godotenv.Load(rcFile)
+func (instance *Instance) loadRcConfig() error {
+	// Read rc file for instance, without touching the opsctl environment:
+	// instances are loaded concurrently
+	rcFile := instance.RcFilePath()
+
+	rcVars, err := godotenv.Read(rcFile)
 	if err != nil {
 		errMsg := fmt.Sprintf("Unable to load instance config at %s", rcFile)
 		return errors.New(errMsg)
@@ -152,19 +222,37 @@ func (instance *Instance) LoadRcConfig() error {
 	// Load mandatory variables
 	vars := make(map[string]string)
 	for _, v := range instance.Config.MandatoryRcVars {
-		val := os.Getenv(v)
+		val := rcVars[v]
 		if val == "" {
-			errMsg := fmt.Sprintf("%s definition missing in %s", v, filepath.Join(
-				instance.Config.Workdir,
-				fmt.Sprintf("%s.rc", instance.Config.Type),
-			))
+			errMsg := fmt.Sprintf("%s definition missing in %s", v, rcFile)
 			return errors.New(errMsg)
 		}
 		vars[v] = val
 	}
 
+	for _, v := range optionalRcVars {
+		val := rcVars[v]
+		if val != "" {
+			vars[v] = val
+		}
+	}
+	for _, v := range instance.Config.OptionalRcVars {
+		val := rcVars[v]
+		if val != "" {
+			vars[v] = val
+		}
+	}
+
+	// Variables exported to the process
+	instance.Config.Env = loadEnv(rcVars)
+	for k, v := range rcVars {
+		if strings.HasPrefix(k, envPrefix) || strings.HasPrefix(k, sidecarPrefix) {
+			vars[k] = v
+		}
+	}
+
 	// Load package version if not found in rc file
-	packageVersion := os.Getenv(packageVersionVar)
+	packageVersion := rcVars[packageVersionVar]
 	if packageVersion == "" {
 		vars[packageVersionVar] = "active_prod"
 	} else {
@@ -173,13 +261,60 @@ func (instance *Instance) LoadRcConfig() error {
 
 	instance.Config.RcValues = vars
 
+	// Parse instances this one depends on
+	dependencies, err := ParseDependsOn(vars[dependsOnVar])
+	if err != nil {
+		errMsg := fmt.Sprintf("Invalid %s in %s: %s", dependsOnVar, rcFile, err)
+		return errors.New(errMsg)
+	}
+	instance.Config.Dependencies = dependencies
+
+	labels, err := ParseLabels(vars[labelsVar])
+	if err != nil {
+		errMsg := fmt.Sprintf("Invalid %s in %s: %s", labelsVar, rcFile, err)
+		return errors.New(errMsg)
+	}
+	instance.Config.Labels = labels
+
+	// Parse resource limits
+	limits, err := utils.ParseProcessLimits(vars)
+	if err != nil {
+		errMsg := fmt.Sprintf("%s in %s", err, rcFile)
+		return errors.New(errMsg)
+	}
+	instance.Config.Limits = limits
+
+	// Resolve the user to run as
+	credential, err := loadRunAs(vars)
+	if err != nil {
+		errMsg := fmt.Sprintf("%s in %s", err, rcFile)
+		return errors.New(errMsg)
+	}
+	instance.Config.Credential = credential
+
+	// Arguments appended to the startup command
+	extraArgs, err := loadExtraArgs(vars)
+	if err != nil {
+		errMsg := fmt.Sprintf("%s in %s", err, rcFile)
+		return errors.New(errMsg)
+	}
+	instance.Config.ExtraArgs = extraArgs
+
+	// Sidecars declared in the rc file, packages may add their own
+	sidecars, err := loadSidecars(vars)
+	if err != nil {
+		errMsg := fmt.Sprintf("%s in %s", err, rcFile)
+		return errors.New(errMsg)
+	}
+	instance.Config.Sidecars = sidecars
+
 	return nil
 }
 
 func (instance Instance) Preflight() error {
 	if !instance.State.Exists {
-		instance.LogMsg("Does not exist.")
-		os.Exit(1)
+		instance.LogMsg(instance.Errors.Exists.Error())
+		return instance.Errors.Exists
 	}
 
 	if instance.Errors.Config != nil {
@@ -190,7 +325,13 @@ func (instance Instance) Preflight() error {
 	if !instance.State.Enabled {
 		errMsg := "Instance is not enabled"
 		instance.LogMsg(errMsg)
-		return errors.New(errMsg)
+		return NewError(KindDisabled, errors.New(errMsg))
+	}
+
+	err := instance.checkRunAs()
+	if err != nil {
+		instance.LogMsg(err.Error())
+		return NewError(KindBadConfig, err)
 	}
 
 	return nil
@@ -202,40 +343,138 @@ func (instance Instance) RunInstanceProcess(startupGracePeriod time.Duration) er
 		return instance.Errors.Config
 	}
 
+	// Render config files from the workdir templates
+	err := instance.ApplyTemplates(false)
+	if err != nil {
+		instance.LogMsg(err.Error())
+		return err
+	}
+
 	// Define the log path to write on
-	logPath := filepath.Join(
-		instance.Config.Workdir,
-		fmt.Sprintf("%s.log", instance.Config.Type),
-	)
+	logPath := instance.LogPath()
+
+	// Rotate the previous run's log if due
+	err = instance.RotateLogs(instance.RotatePolicy(), false)
+	if err != nil {
+		instance.LogMsg(fmt.Sprintf("Log rotation failed: %s", err))
+	}
 
-	// Run process detached
-	err := utils.RunDetachedProcess(logPath, instance.Config.StartupArgs)
+	// Run process detached, with its resource limits
+	cgroupPath := instance.prepareCgroup()
+	spawnedPid, err := utils.RunDetachedProcess(logPath, instance.Config.StartupArgs, utils.SpawnOptions{
+		Limits:     instance.Config.Limits,
+		CgroupPath: cgroupPath,
+		Credential: instance.spawnCredential(),
+		Env:        childEnvList(instance.ChildEnv()),
+	})
 	if err != nil {
+		instance.LogMsg(err.Error())
 		return err
 	}
 
 	// Wait for the process to be started
 	time.Sleep(100 * time.Millisecond)
-	pid, err := utils.WaitForProcess(instance.Config.RuntimeArgs, startupGracePeriod)
+	pid, err := utils.WaitForProcess(spawnedPid, instance.Config.RuntimeArgs, startupGracePeriod)
+	if err != nil {
+		instance.LogMsg(err.Error())
+		return NewError(KindStartTimeout, err)
+	}
+
+	// Record pid and start time so later runs can find this exact process
+	err = utils.WritePidFile(instance.PidFilePath(), pid)
 	if err != nil {
 		instance.LogMsg(err.Error())
-		return err
 	}
 
 	instance.LogMsg(fmt.Sprintf("Started with pid=%d", pid))
 
+	err = instance.WaitForReady(pid)
+	if err != nil {
+		instance.LogMsg(err.Error())
+		return NewError(KindStartTimeout, err)
+	}
+
 	return nil
 }
 
+// RcDuration returns the duration set for name in the rc file, or defaultValue
+func (instance Instance) RcDuration(name string, defaultValue time.Duration) time.Duration {
+	value, found := instance.Config.RcValues[name]
+	if !found {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		instance.LogMsg(fmt.Sprintf("Invalid duration %s=%s, using %s", name, value, defaultValue))
+		return defaultValue
+	}
+	return duration
+}
+
+// ProbeTimeout is the timeout of a single probe check, PROBE_TIMEOUT in the rc file
+func (instance Instance) ProbeTimeout() time.Duration {
+	return instance.RcDuration(probeTimeoutVar, defaultProbeTimeout)
+}
+
+// ReadinessTimeout is the package readiness timeout, or READINESS_TIMEOUT in the rc file
+func (instance Instance) ReadinessTimeout() time.Duration {
+	return instance.RcDuration(readinessTimeoutVar, instance.Config.ReadinessTimeout)
+}
+
+// WaitForReady waits for all readiness probes to pass, for up to the package
+// readiness timeout or READINESS_TIMEOUT in the rc file.
+func (instance Instance) WaitForReady(pid int) error {
+	if len(instance.Config.ReadinessProbes) == 0 {
+		return nil
+	}
+	timeout := instance.ReadinessTimeout()
+	var err error
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(readinessPollingTime) {
+		if !utils.ProcessMatches(pid, instance.Config.RuntimeArgs) {
+			errMsg := fmt.Sprintf("Process pid=%d exited before being ready", pid)
+			return errors.New(errMsg)
+		}
+		err = utils.CheckProbes(instance.Config.ReadinessProbes)
+		if err == nil {
+			instance.LogMsg("Ready")
+			return nil
+		}
+	}
+	errMsg := fmt.Sprintf("Instance not ready within %s: %s", timeout, err)
+	return errors.New(errMsg)
+}
+
+// Health runs liveness probes of a running instance and checks its sidecars,
+// and returns HEALTHY or DEGRADED, or an empty string when there is nothing
+// to check. A sidecar not running is reported as a SidecarDownError.
+func (instance Instance) Health() (string, error) {
+	if !instance.State.Up || (len(instance.Config.LivenessProbes) == 0 && len(instance.Config.Sidecars) == 0) {
+		return "", nil
+	}
+	err := utils.CheckProbes(instance.Config.LivenessProbes)
+	if err != nil {
+		return "DEGRADED", err
+	}
+	err = instance.checkSidecars()
+	if err != nil {
+		return "DEGRADED", err
+	}
+	return "HEALTHY", nil
+}
+
 func (instance Instance) TerminateInstanceProcess(sigtermGracePeriod time.Duration, sigkillGracePeriod time.Duration) error {
 	pid := instance.State.PID
 	syscall.Kill(pid, syscall.SIGTERM)
 	// Wait for grace period
 	for start := time.Now(); time.Since(start) < sigtermGracePeriod; {
 		time.Sleep(50 * time.Millisecond)
+		utils.RefreshProcTable()
 		isUp, _ := instance.IsUp()
 		if !isUp {
-			log.Printf("Terminated pid=%d with SIGTERM", pid)
+			utils.RemovePidFile(instance.PidFilePath())
+			utils.RemoveCgroup(instance.CgroupPath())
+			instance.noteTermination("SIGTERM")
+			instance.LogMsg(fmt.Sprintf("Terminated pid=%d with SIGTERM", pid))
 			return nil
 		}
 	}
@@ -243,21 +482,37 @@ func (instance Instance) TerminateInstanceProcess(sigtermGracePeriod time.Durati
 	// Wait for grace period
 	for start := time.Now(); time.Since(start) < sigkillGracePeriod; {
 		time.Sleep(50 * time.Millisecond)
+		utils.RefreshProcTable()
 		isUp, _ := instance.IsUp()
 		if !isUp {
-			log.Printf("Terminated pid=%d with SIGKILL", pid)
+			utils.RemovePidFile(instance.PidFilePath())
+			utils.RemoveCgroup(instance.CgroupPath())
+			instance.noteTermination("SIGKILL")
+			instance.LogMsg(fmt.Sprintf("Terminated pid=%d with SIGKILL", pid))
 			return nil
 		}
 	}
-	return errors.New("Failed to terminate process within grace period.")
+	errMsg := fmt.Sprintf("Failed to terminate pid=%d within grace period.", pid)
+	instance.LogMsg(errMsg)
+	return NewError(KindKillFailed, errors.New(errMsg))
 }
 
 func DiscoverInstanceTypes() []string {
+	instanceTypes, err := ListInstanceTypes()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return instanceTypes
+}
+
+// ListInstanceTypes works as DiscoverInstanceTypes, returning errors instead
+// of exiting, for long-running callers
+func ListInstanceTypes() ([]string, error) {
 	env := utils.LoadOpsctlEnv()
 	instancesBase := filepath.Join(env.Home, "instances")
 	files, err := ioutil.ReadDir(instancesBase)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	instanceTypes := make([]string, 0)
 	for _, f := range files {
@@ -265,15 +520,25 @@ func DiscoverInstanceTypes() []string {
 			instanceTypes = append(instanceTypes, f.Name())
 		}
 	}
-	return instanceTypes
+	return instanceTypes, nil
 }
 
 func DiscoverInstances(instanceType string) []string {
+	instances, err := ListInstances(instanceType)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return instances
+}
+
+// ListInstances works as DiscoverInstances, returning errors instead of
+// exiting, for long-running callers
+func ListInstances(instanceType string) ([]string, error) {
 	env := utils.LoadOpsctlEnv()
 	instancesBase := filepath.Join(env.Home, "instances", instanceType)
 	files, err := ioutil.ReadDir(instancesBase)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	instances := make([]string, 0)
 	for _, f := range files {
@@ -281,7 +546,7 @@ func DiscoverInstances(instanceType string) []string {
 			instances = append(instances, f.Name())
 		}
 	}
-	return instances
+	return instances, nil
 }
 
 func (instance Instance) LogMsg(msg string) {
@@ -339,14 +604,18 @@ func (instance Instance) ToolkitRow() []string {
 		dataDirSizeStr = fmt.Sprintf("%d MB", dataSize/1e6)
 	}
 
+	health, _ := instance.Health()
+
 	// Build columns:
 	row := make([]string, 0)
 	// Row name column (instance)
 	row = append(row, fmt.Sprintf("%s - %s", instance.Config.Type, instance.Config.Name))
 	// Status column
 	row = append(row, state)
+	// Health column
+	row = append(row, health)
 	// Port column
-	row = append(row, "TODO")
+	row = append(row, utils.JoinPorts(instance.ListeningPorts()))
 	// Instance type column
 	row = append(row, instance.Config.Type)
 	// Instance name column
@@ -369,11 +638,15 @@ func (instance Instance) ToolkitRow() []string {
 	return row
 }
 
-func (instance Instance) PrintSummary() {
+// PrintSummary shows the values exported through ENV_ only with showEnv
+func (instance Instance) PrintSummary(showEnv bool) {
 	tableData := make([][]string, 0)
 	tableData = append(tableData, []string{"Type", instance.Config.Type})
 	tableData = append(tableData, []string{"Name", instance.Config.Name})
 	tableData = append(tableData, []string{"Path", instance.Config.Workdir})
+	if len(instance.Config.Labels) > 0 {
+		tableData = append(tableData, []string{"Labels", JoinLabels(instance.Config.Labels)})
+	}
 	enabled := "N"
 	if instance.State.Enabled {
 		enabled = "Y"
@@ -390,12 +663,63 @@ func (instance Instance) PrintSummary() {
 	if instance.State.Up {
 		pid := fmt.Sprintf("%d", instance.State.PID)
 		tableData = append(tableData, []string{"PID", pid})
+		tableData = append(tableData, []string{"User", instance.Owner()})
+		health, err := instance.Health()
+		if err != nil {
+			health = fmt.Sprintf("%s (%s)", health, err)
+		}
+		if health != "" {
+			tableData = append(tableData, []string{"Health", health})
+		}
+	}
+
+	if instance.State.Up {
+		effective := instance.EffectiveLimits()
+		for _, v := range utils.LimitRcVars {
+			value, found := effective[v]
+			if !found {
+				continue
+			}
+			if configured := instance.Config.Limits.Values()[v]; configured != "" && configured != value {
+				value = fmt.Sprintf("%s (configured %s)", value, configured)
+			}
+			tableData = append(tableData, []string{"Limit", fmt.Sprintf("%s=%s", v, value)})
+		}
+	}
+
+	ports, _ := instance.Ports()
+	for _, v := range instance.Config.PortRcVars {
+		if port, found := ports[v]; found {
+			tableData = append(tableData, []string{"Port", fmt.Sprintf("%d (%s)", port, v)})
+		}
+	}
+	if instance.State.Up {
+		tableData = append(tableData, []string{"Listening on", utils.JoinPorts(instance.ListeningPorts())})
+	}
+
+	for _, probe := range instance.Config.ReadinessProbes {
+		tableData = append(tableData, []string{"Readiness probe", probe.String()})
+	}
+	for _, probe := range instance.Config.LivenessProbes {
+		tableData = append(tableData, []string{"Liveness probe", probe.String()})
+	}
+
+	tableData = append(tableData, []string{"Command", utils.JoinArgs(instance.Config.StartupArgs)})
+	for _, sidecar := range instance.SidecarSummaries() {
+		desc := fmt.Sprintf("%s %s: %s", sidecar.Name, sidecar.State, utils.JoinArgs(sidecar.StartupArgs))
+		if sidecar.PID > 0 {
+			desc = fmt.Sprintf("%s %s pid=%d: %s", sidecar.Name, sidecar.State, sidecar.PID, utils.JoinArgs(sidecar.StartupArgs))
+		}
+		tableData = append(tableData, []string{"Sidecar", desc})
+	}
+	for _, v := range childEnvList(instance.ReportedEnv(showEnv)) {
+		tableData = append(tableData, []string{"Env", v})
 	}
 
 	if len(instance.Config.RcValues) > 0 {
 		tableData = append(tableData, []string{"", ""})
 
-		for k, v := range instance.Config.RcValues {
+		for k, v := range instance.ReportedRcValues(showEnv) {
 			rcVal := fmt.Sprintf("%s=%s", k, v)
 			tableData = append(tableData, []string{"", rcVal})
 		}
@@ -431,6 +755,8 @@ func (instance Instance) StatusRow() []string {
 		pid = fmt.Sprintf("%d", instance.State.PID)
 	}
 
+	health, _ := instance.Health()
+
 	errors := ""
 
 	if instance.Errors.Config != nil {
@@ -442,7 +768,10 @@ func (instance Instance) StatusRow() []string {
 		instance.Config.Name,
 		enabled,
 		up,
+		health,
 		pid,
+		instance.Owner(),
+		utils.JoinPorts(instance.ListeningPorts()),
 		errors,
 	}
 }
@@ -0,0 +1,196 @@
+package instance
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+// Lifecycle actions are appended to $OPSCTL_HOME/journal.jsonl, one JSON
+// object per line
+const journalFilename = "journal.jsonl"
+
+// The journal is renamed to journal.jsonl.1, .2... past MaxSize, keeping as
+// many files as archived logs
+var journalRotatePolicy = utils.RotatePolicy{
+	MaxSize: 10 << 20,
+	Keep:    defaultRotatePolicy.Keep,
+}
+
+// Journal outcomes
+const (
+	OutcomeOK      = "ok"
+	OutcomeFailed  = "failed"
+	OutcomeSkipped = "skipped"
+)
+
+// JournalEntry records a lifecycle action on an instance
+type JournalEntry struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Host    string    `json:"host"`
+	Command string    `json:"command"`
+	Type    string    `json:"type"`
+	Name    string    `json:"name"`
+	// start, stop, restart, enable, disable or crash
+	Action  string `json:"action"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	// Pid before and after the action, 0 when not running
+	PIDBefore int `json:"pid_before,omitempty"`
+	PIDAfter  int `json:"pid_after,omitempty"`
+	// SIGTERM or SIGKILL, when the action terminated the process
+	Signal string `json:"signal,omitempty"`
+}
+
+// Signals which ended instance processes, since the last journal entry of
+// each instance
+var terminations = struct {
+	sync.Mutex
+	signals map[InstanceRef]string
+}{signals: make(map[InstanceRef]string)}
+
+func (instance Instance) noteTermination(signal string) {
+	terminations.Lock()
+	defer terminations.Unlock()
+	terminations.signals[instance.Ref()] = signal
+}
+
+// JournalPath returns the path of the audit journal in home
+func JournalPath(home string) string {
+	return filepath.Join(home, journalFilename)
+}
+
+// JournalPaths returns the paths of the audit journal in home, rotated ones
+// included, oldest first
+func JournalPaths(home string) []string {
+	paths := make([]string, 0)
+	for i := journalRotatePolicy.Keep; i > 0; i-- {
+		paths = append(paths, fmt.Sprintf("%s.%d", JournalPath(home), i))
+	}
+	return append(paths, JournalPath(home))
+}
+
+// rotateJournal renames the journal once it is larger than MaxSize. Writers
+// which still have it open carry on appending to the rotated file.
+func rotateJournal(home string) error {
+	stat, err := os.Stat(JournalPath(home))
+	if err != nil || stat.Size() < journalRotatePolicy.MaxSize {
+		return nil
+	}
+	paths := JournalPaths(home)
+	// The oldest one is overwritten by the next
+	for i := 1; i < len(paths); i++ {
+		err = os.Rename(paths[i], paths[i-1])
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// invokingUser returns who ran opsctl, seen through sudo
+func invokingUser() string {
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		return sudoUser
+	}
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
+
+// Journal appends an action on the instance to the audit journal. Time, user,
+// host, command line and the terminating signal are filled in.
+func (instance Instance) Journal(entry JournalEntry) error {
+	entry.Time = time.Now()
+	entry.User = invokingUser()
+	entry.Host, _ = os.Hostname()
+	entry.Command = utils.JoinArgs(os.Args)
+	entry.Type = instance.Config.Type
+	entry.Name = instance.Config.Name
+
+	terminations.Lock()
+	if signal, found := terminations.signals[instance.Ref()]; found {
+		entry.Signal = signal
+		delete(terminations.signals, instance.Ref())
+	}
+	terminations.Unlock()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = rotateJournal(instance.OpsctlEnv.Home)
+	if err != nil {
+		return err
+	}
+	// Lines are written at once in append mode, so concurrent opsctl runs
+	// do not interleave them. Command lines may reveal more than status.
+	f, err := os.OpenFile(JournalPath(instance.OpsctlEnv.Home), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadJournal returns the entries of the audit journal in home selected by
+// keep, oldest first. Lines which cannot be parsed are skipped.
+func ReadJournal(home string, keep func(JournalEntry) bool) ([]JournalEntry, error) {
+	entries := make([]JournalEntry, 0)
+	for _, path := range JournalPaths(home) {
+		var err error
+		entries, err = readJournalFile(path, keep, entries)
+		if err != nil {
+			return entries, err
+		}
+	}
+	return entries, nil
+}
+
+func readJournalFile(path string, keep func(JournalEntry) bool, entries []JournalEntry) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return entries, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReaderSize(f, 1024*1024)
+	for {
+		line, isPrefix, err := reader.ReadLine()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		if isPrefix {
+			// Longer than any entry, skip the whole line
+			for isPrefix && err == nil {
+				_, isPrefix, err = reader.ReadLine()
+			}
+			continue
+		}
+		var entry JournalEntry
+		if json.Unmarshal(line, &entry) == nil && keep(entry) {
+			entries = append(entries, entry)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LABELS in the rc file tags an instance for selection, e.g.
+// LABELS=team=payments,env=prod
+const labelsVar = "LABELS"
+
+// ParseLabels parses a comma separated list of key=value labels
+func ParseLabels(value string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" || strings.ContainsAny(key, " \t") {
+			errMsg := fmt.Sprintf("Invalid label '%s', expected key=value", item)
+			return nil, errors.New(errMsg)
+		}
+		labels[key] = strings.TrimSpace(parts[1])
+	}
+	return labels, nil
+}
+
+// JoinLabels formats labels as in the rc file, sorted by key
+func JoinLabels(labels map[string]string) string {
+	items := make([]string, 0, len(labels))
+	for key, value := range labels {
+		items = append(items, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,46 @@
+package instance
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"team=payments", map[string]string{"team": "payments"}, false},
+		{" team = payments , env=prod,", map[string]string{"team": "payments", "env": "prod"}, false},
+		{"url=http://host/?a=b", map[string]string{"url": "http://host/?a=b"}, false},
+		{"empty=", map[string]string{"empty": ""}, false},
+		{"team=a,team=b", map[string]string{"team": "b"}, false},
+		{"team", nil, true},
+		{"=payments", nil, true},
+		{"my team=payments", nil, true},
+	}
+	for _, test := range tests {
+		got, err := ParseLabels(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseLabels(%q) error = %v, wantErr %v", test.value, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseLabels(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestJoinLabels(t *testing.T) {
+	labels := map[string]string{"team": "payments", "env": "prod"}
+	want := "env=prod,team=payments"
+	if got := JoinLabels(labels); got != want {
+		t.Errorf("JoinLabels() = %q, want %q", got, want)
+	}
+	parsed, err := ParseLabels(want)
+	if err != nil || !reflect.DeepEqual(parsed, labels) {
+		t.Errorf("ParseLabels(JoinLabels()) = %v, %v, want %v", parsed, err, labels)
+	}
+}
@@ -0,0 +1,203 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+// WorkdirLayout lists directories and files a package expects in the
+// instance workdir, relative to it. Files are created empty.
+type WorkdirLayout struct {
+	Dirs  []string
+	Files []string
+}
+
+var rcVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (instance Instance) RcFilePath() string {
+	return filepath.Join(
+		instance.Config.Workdir,
+		fmt.Sprintf("%s.rc", instance.Config.Type),
+	)
+}
+
+func (instance Instance) enabledFlagPath() string {
+	return filepath.Join(instance.Config.Workdir, "enabled.flag")
+}
+
+// Create scaffolds the workdir of a new instance: rc file, package layout
+// and enabled flag.
+func (instance Instance) Create(rcValues map[string]string, enabled bool) error {
+	err := instance.Ref().Validate()
+	if err != nil {
+		return err
+	}
+	err = instance.checkWorkdir()
+	if err != nil {
+		return err
+	}
+	if instance.State.Exists {
+		errMsg := fmt.Sprintf("Instance directory %s already exists.", instance.Config.Workdir)
+		return errors.New(errMsg)
+	}
+
+	// Validate the rc values before touching anything
+	for name := range rcValues {
+		if !rcVarName.MatchString(name) {
+			errMsg := fmt.Sprintf("Invalid rc variable name '%s'", name)
+			return errors.New(errMsg)
+		}
+	}
+	missing := make([]string, 0)
+	for _, v := range instance.Config.MandatoryRcVars {
+		if rcValues[v] == "" {
+			missing = append(missing, v)
+		}
+	}
+	if len(missing) > 0 {
+		errMsg := fmt.Sprintf("Missing mandatory rc variables for type '%s': %s", instance.Config.Type, strings.Join(missing, ", "))
+		return errors.New(errMsg)
+	}
+
+	err = os.MkdirAll(filepath.Dir(instance.Config.Workdir), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Mkdir(instance.Config.Workdir, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = writeRcFile(instance.RcFilePath(), rcValues)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range instance.Config.Layout.Dirs {
+		err = os.MkdirAll(filepath.Join(instance.Config.Workdir, dir), 0755)
+		if err != nil {
+			return err
+		}
+	}
+	for _, file := range instance.Config.Layout.Files {
+		path := filepath.Join(instance.Config.Workdir, file)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(path, []byte{}, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if enabled {
+		return touchFile(instance.enabledFlagPath())
+	}
+	return nil
+}
+
+// checkWorkdir refuses to create or delete anything but
+// $OPSCTL_HOME/instances/<type>/<name>
+func (instance Instance) checkWorkdir() error {
+	err := instance.Ref().CheckPath()
+	if err != nil {
+		return err
+	}
+	expected := filepath.Join(instance.OpsctlEnv.Home, "instances", instance.Config.Type, instance.Config.Name)
+	if instance.Config.Workdir != expected {
+		errMsg := fmt.Sprintf("Instance directory %s is not %s", instance.Config.Workdir, expected)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+func touchFile(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func writeRcFile(path string, rcValues map[string]string) error {
+	names := make([]string, 0, len(rcValues))
+	for name := range rcValues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var content strings.Builder
+	for _, name := range names {
+		value := rcValues[name]
+		// Quote values godotenv would otherwise split or cut at a comment
+		if strings.ContainsAny(value, " \t#'\"\\$") {
+			value = fmt.Sprintf("\"%s\"", strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`).Replace(value))
+		}
+		fmt.Fprintf(&content, "%s=%s\n", name, value)
+	}
+	return ioutil.WriteFile(path, []byte(content.String()), 0644)
+}
+
+func (instance Instance) Enable() error {
+	if !instance.State.Exists {
+		return instance.Errors.Exists
+	}
+	return touchFile(instance.enabledFlagPath())
+}
+
+func (instance Instance) Disable() error {
+	if !instance.State.Exists {
+		return instance.Errors.Exists
+	}
+	err := os.Remove(instance.enabledFlagPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Remove deletes the instance workdir, after archiving it into
+// $OPSCTL_HOME/archived_instances/<type>/ when archive is set.
+func (instance Instance) Remove(archive bool) (string, error) {
+	err := instance.checkWorkdir()
+	if err != nil {
+		return "", err
+	}
+	if !instance.State.Exists {
+		return "", instance.Errors.Exists
+	}
+	if instance.State.Up {
+		errMsg := fmt.Sprintf("Instance is running with pid=%d, stop it first.", instance.State.PID)
+		return "", errors.New(errMsg)
+	}
+
+	archivePath := ""
+	if archive {
+		archiveDir := filepath.Join(instance.OpsctlEnv.Home, "archived_instances", instance.Config.Type)
+		err = os.MkdirAll(archiveDir, 0755)
+		if err != nil {
+			return "", err
+		}
+		archivePath = filepath.Join(
+			archiveDir,
+			fmt.Sprintf("%s-%s.tar.gz", instance.Config.Name, time.Now().Format("20060102-150405")),
+		)
+		err = utils.CreateTarGz(instance.Config.Workdir, archivePath)
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed archiving %s: %s", instance.Config.Workdir, err)
+			return "", errors.New(errMsg)
+		}
+	}
+
+	return archivePath, os.RemoveAll(instance.Config.Workdir)
+}
@@ -0,0 +1,48 @@
+package instance
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+// CgroupPath is the cgroup of the instance, under the delegated cgroup v2
+// directory set by OPSCTL_CGROUP in ~/.opsctl. Empty when not set.
+func (instance Instance) CgroupPath() string {
+	if instance.OpsctlEnv.Cgroup == "" {
+		return ""
+	}
+	return filepath.Join(
+		instance.OpsctlEnv.Cgroup,
+		fmt.Sprintf("%s-%s", instance.Config.Type, instance.Config.Name),
+	)
+}
+
+// prepareCgroup sets up the instance cgroup when MEMORY_MAX or CPU_QUOTA is
+// set. Returns the cgroup to start the process in, empty if none.
+// Limits which cannot be applied are reported without failing the start.
+func (instance Instance) prepareCgroup() string {
+	limits := instance.Config.Limits
+	if !limits.NeedsCgroup() {
+		return ""
+	}
+	if instance.CgroupPath() == "" {
+		instance.LogMsg(fmt.Sprintf("%s and %s not applied: OPSCTL_CGROUP, a writable cgroup v2 directory, is not set in ~/.opsctl", utils.MemoryMaxVar, utils.CPUQuotaVar))
+		return ""
+	}
+	cgroupPath, err := utils.PrepareCgroup(instance.OpsctlEnv.Cgroup, filepath.Base(instance.CgroupPath()), limits)
+	if err != nil {
+		instance.LogMsg(fmt.Sprintf("%s and %s not applied: %s", utils.MemoryMaxVar, utils.CPUQuotaVar, err))
+		return ""
+	}
+	return cgroupPath
+}
+
+// EffectiveLimits returns the limits the running instance process is subject to
+func (instance Instance) EffectiveLimits() map[string]string {
+	if !instance.State.Up {
+		return map[string]string{}
+	}
+	return utils.EffectiveLimits(instance.State.PID)
+}
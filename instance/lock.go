@@ -0,0 +1,54 @@
+package instance
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+// Home-wide lock file, in $OPSCTL_HOME
+const lockFilename = ".opsctl.lock"
+
+// HomeLockPath returns the path of the home-wide lock. Operations on all
+// instances and package changes take it exclusively, operations on a single
+// instance take it shared.
+func HomeLockPath(home string) string {
+	return filepath.Join(home, lockFilename)
+}
+
+// LockPath returns the path of the instance lock, derived from type and name
+// so that it also serializes the creation of the instance
+func (instance Instance) LockPath() string {
+	return filepath.Join(
+		instance.OpsctlEnv.Home,
+		"locks",
+		instance.Config.Type,
+		instance.Config.Name+".lock",
+	)
+}
+
+// Lock takes the locks a mutating operation on the instance needs, waiting
+// for up to timeout for other opsctl processes to release them. The returned
+// function releases them.
+func (instance Instance) Lock(timeout time.Duration) (func(), error) {
+	homeLock, err := utils.AcquireLock(HomeLockPath(instance.OpsctlEnv.Home), false, timeout)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(instance.LockPath()), 0755)
+	if err != nil {
+		homeLock.Release()
+		return nil, err
+	}
+	instanceLock, err := utils.AcquireLock(instance.LockPath(), true, timeout)
+	if err != nil {
+		homeLock.Release()
+		return nil, err
+	}
+	return func() {
+		instanceLock.Release()
+		homeLock.Release()
+	}, nil
+}
@@ -0,0 +1,106 @@
+package instance
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+const (
+	logMaxSizeVar      = "LOG_MAX_SIZE"
+	logMaxAgeVar       = "LOG_MAX_AGE"
+	logKeepVar         = "LOG_KEEP"
+	logMaxTotalSizeVar = "LOG_MAX_TOTAL_SIZE"
+)
+
+// Applies unless overridden in the rc file
+var defaultRotatePolicy = utils.RotatePolicy{
+	MaxSize: 100 << 20,
+	Keep:    10,
+}
+
+// LogPath is where the instance process stdout and stderr are written
+func (instance Instance) LogPath() string {
+	return filepath.Join(
+		instance.Config.Workdir,
+		fmt.Sprintf("%s.log", instance.Config.Type),
+	)
+}
+
+// LogFiles returns the instance log and the sidecar logs, followed by logs the
+// service writes on its own, declared by the package relative to the workdir (globs allowed).
+func (instance Instance) LogFiles() []string {
+	logFiles := []string{instance.LogPath()}
+	for _, sidecar := range instance.Config.Sidecars {
+		logFiles = append(logFiles, instance.SidecarLogPath(sidecar))
+	}
+	for _, pattern := range instance.Config.LogFiles {
+		matches, err := filepath.Glob(filepath.Join(instance.Config.Workdir, pattern))
+		if err != nil {
+			continue
+		}
+		logFiles = append(logFiles, matches...)
+	}
+	return logFiles
+}
+
+func (instance Instance) ArchivedLogsDir() string {
+	return filepath.Join(
+		instance.OpsctlEnv.Home,
+		"archived_logs",
+		instance.Config.Type,
+		instance.Config.Name,
+	)
+}
+
+// RotatePolicy returns the log rotation policy, with LOG_MAX_SIZE, LOG_MAX_AGE,
+// LOG_KEEP and LOG_MAX_TOTAL_SIZE from the rc file applied.
+func (instance Instance) RotatePolicy() utils.RotatePolicy {
+	policy := defaultRotatePolicy
+	rc := instance.Config.RcValues
+	if value, found := rc[logMaxSizeVar]; found {
+		size, err := utils.ParseByteSize(value)
+		if err == nil {
+			policy.MaxSize = size
+		} else {
+			instance.LogMsg(fmt.Sprintf("Invalid %s=%s, using default", logMaxSizeVar, value))
+		}
+	}
+	policy.MaxAge = instance.RcDuration(logMaxAgeVar, policy.MaxAge)
+	if value, found := rc[logKeepVar]; found {
+		keep, err := strconv.Atoi(value)
+		if err == nil {
+			policy.Keep = keep
+		} else {
+			instance.LogMsg(fmt.Sprintf("Invalid %s=%s, using default", logKeepVar, value))
+		}
+	}
+	if value, found := rc[logMaxTotalSizeVar]; found {
+		size, err := utils.ParseByteSize(value)
+		if err == nil {
+			policy.MaxTotalSize = size
+		} else {
+			instance.LogMsg(fmt.Sprintf("Invalid %s=%s, using default", logMaxTotalSizeVar, value))
+		}
+	}
+	return policy
+}
+
+// RotateLogs archives the instance log into $OPSCTL_HOME/archived_logs/<type>/<name>/
+// when due (or forced), then enforces retention of the archives.
+func (instance Instance) RotateLogs(policy utils.RotatePolicy, force bool) error {
+	archivePath, err := utils.RotateLog(instance.LogPath(), instance.ArchivedLogsDir(), policy, force)
+	if err != nil {
+		return err
+	}
+	if archivePath != "" {
+		instance.LogMsg(fmt.Sprintf("Rotated %s to %s", instance.LogPath(), archivePath))
+	}
+	removed, err := utils.EnforceRetention(instance.ArchivedLogsDir(), policy)
+	for _, path := range removed {
+		instance.LogMsg(fmt.Sprintf("Removed archived log %s", path))
+	}
+	return err
+}
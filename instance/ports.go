@@ -0,0 +1,101 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+// Ports returns the ports set in the rc file for the variables the package
+// declares as ports, e.g. NETPROBE_LISTEN_PORT
+func (instance Instance) Ports() (map[string]int, error) {
+	ports := make(map[string]int)
+	for _, v := range instance.Config.PortRcVars {
+		value, found := instance.Config.RcValues[v]
+		if !found || value == "" {
+			continue
+		}
+		port, err := utils.ParsePort(value)
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid %s in %s: %s", v, instance.RcFilePath(), err)
+			return ports, errors.New(errMsg)
+		}
+		ports[v] = port
+	}
+	return ports, nil
+}
+
+// ListeningPorts returns the TCP ports the running instance process listens on
+func (instance Instance) ListeningPorts() []int {
+	if !instance.State.Up {
+		return []int{}
+	}
+	ports, err := utils.ListeningPorts(instance.State.PID)
+	if err != nil {
+		return []int{}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// ListeningPortsWithSidecars returns the TCP ports the running instance
+// process and its sidecars listen on
+func (instance Instance) ListeningPortsWithSidecars() []int {
+	ports := instance.ListeningPorts()
+	if !instance.State.Up {
+		return ports
+	}
+	for _, sidecar := range instance.Config.Sidecars {
+		up, pid := instance.SidecarIsUp(sidecar)
+		if !up {
+			continue
+		}
+		sidecarPorts, err := utils.ListeningPorts(pid)
+		if err != nil {
+			continue
+		}
+		ports = append(ports, sidecarPorts...)
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// WaitForPorts waits for the running instance process to listen on all the
+// ports of its rc file, for up to timeout. Ports of sidecars are left out: a
+// sidecar down only degrades the instance.
+func (instance Instance) WaitForPorts(timeout time.Duration) error {
+	ports, err := instance.Ports()
+	if err != nil {
+		return err
+	}
+	for _, sidecar := range instance.Config.Sidecars {
+		for _, v := range sidecar.PortRcVars {
+			delete(ports, v)
+		}
+	}
+	missing := make([]int, 0)
+	for start := time.Now(); ; time.Sleep(readinessPollingTime) {
+		listening := make(map[int]bool)
+		for _, port := range instance.ListeningPorts() {
+			listening[port] = true
+		}
+		missing = missing[:0]
+		for _, port := range ports {
+			if !listening[port] {
+				missing = append(missing, port)
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		if time.Since(start) >= timeout {
+			break
+		}
+	}
+	sort.Ints(missing)
+	errMsg := fmt.Sprintf("Instance not listening on %s within %s", utils.JoinPorts(missing), timeout)
+	return errors.New(errMsg)
+}
@@ -0,0 +1,139 @@
+package instance
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+// ReportSchemaVersion must be increased on any incompatible change of
+// InstanceReport, e.g. renaming or removing a field. Adding fields is fine.
+const ReportSchemaVersion = 1
+
+// InstanceReport is the machine-readable view of an instance, as rendered by
+// `--output json|yaml|csv`
+type InstanceReport struct {
+	Type           string            `json:"type" yaml:"type"`
+	Name           string            `json:"name" yaml:"name"`
+	Workdir        string            `json:"workdir" yaml:"workdir"`
+	Exists         bool              `json:"exists" yaml:"exists"`
+	Enabled        bool              `json:"enabled" yaml:"enabled"`
+	State          string            `json:"state" yaml:"state"`
+	Health         string            `json:"health" yaml:"health"`
+	PID            int               `json:"pid" yaml:"pid"`
+	User           string            `json:"user" yaml:"user"`
+	PackageVersion string            `json:"package_version" yaml:"package_version"`
+	RcValues       map[string]string `json:"rc_values" yaml:"rc_values"`
+	Labels         map[string]string `json:"labels" yaml:"labels"`
+	StartupArgs    []string          `json:"startup_args" yaml:"startup_args"`
+	Sidecars       []SidecarReport   `json:"sidecars" yaml:"sidecars"`
+	Env            map[string]string `json:"env" yaml:"env"`
+	Errors         []string          `json:"errors" yaml:"errors"`
+	StartTime      *time.Time        `json:"start_time" yaml:"start_time"`
+	UptimeSeconds  int64             `json:"uptime_seconds" yaml:"uptime_seconds"`
+	Threads        int               `json:"threads" yaml:"threads"`
+	RssBytes       int64             `json:"rss_bytes" yaml:"rss_bytes"`
+	CPUSeconds     float64           `json:"cpu_seconds" yaml:"cpu_seconds"`
+	Ports          map[string]int    `json:"ports" yaml:"ports"`
+	ListeningPorts []int             `json:"listening_ports" yaml:"listening_ports"`
+	Limits         map[string]string `json:"limits" yaml:"limits"`
+	DirSizeBytes   int64             `json:"dir_size_bytes" yaml:"dir_size_bytes"`
+	DataSizeBytes  int64             `json:"data_size_bytes" yaml:"data_size_bytes"`
+}
+
+type SidecarReport struct {
+	Name        string   `json:"name" yaml:"name"`
+	State       string   `json:"state" yaml:"state"`
+	PID         int      `json:"pid" yaml:"pid"`
+	StartupArgs []string `json:"startup_args" yaml:"startup_args"`
+	Log         string   `json:"log" yaml:"log"`
+}
+
+// StateName returns UP, DOWN or DISABLED
+func (instance Instance) StateName() string {
+	if instance.State.Up {
+		return "UP"
+	}
+	if !instance.State.Enabled {
+		return "DISABLED"
+	}
+	return "DOWN"
+}
+
+func (instance Instance) Report() InstanceReport {
+	report := instance.ProcessReport()
+	report.DirSizeBytes, report.DataSizeBytes = instance.DirSizes()
+	return report
+}
+
+// ProcessReport works as Report without the directory sizes, which take long
+// to compute on large data directories
+func (instance Instance) ProcessReport() InstanceReport {
+	report := InstanceReport{
+		Type:           instance.Config.Type,
+		Name:           instance.Config.Name,
+		Workdir:        instance.Config.Workdir,
+		Exists:         instance.State.Exists,
+		Enabled:        instance.State.Enabled,
+		State:          instance.StateName(),
+		PackageVersion: instance.Config.RcValues[packageVersionVar],
+		RcValues:       instance.ReportedRcValues(false),
+		Labels:         instance.Config.Labels,
+		StartupArgs:    instance.Config.StartupArgs,
+		Sidecars:       instance.SidecarSummaries(),
+		Env:            instance.ReportedEnv(false),
+		Errors:         make([]string, 0),
+		ListeningPorts: instance.ListeningPorts(),
+		Limits:         instance.EffectiveLimits(),
+	}
+	report.Ports, _ = instance.Ports()
+	if report.Labels == nil {
+		report.Labels = make(map[string]string)
+	}
+	if report.StartupArgs == nil {
+		report.StartupArgs = make([]string, 0)
+	}
+
+	if instance.Errors.Exists != nil {
+		report.Errors = append(report.Errors, instance.Errors.Exists.Error())
+	}
+	if instance.Errors.Config != nil {
+		report.Errors = append(report.Errors, instance.Errors.Config.Error())
+	}
+
+	if instance.State.Up {
+		report.PID = instance.State.PID
+		report.User = instance.Owner()
+		health, err := instance.Health()
+		report.Health = health
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+		stat, err := utils.GetProcStats(instance.State.PID)
+		if err == nil {
+			report.Threads = stat.NumThreads
+			report.RssBytes = int64(stat.ResidentMemory())
+			report.CPUSeconds = stat.CPUTime()
+			startEpoch, err := stat.StartTime()
+			if err == nil {
+				startTime := time.Unix(int64(startEpoch), 0)
+				report.StartTime = &startTime
+				report.UptimeSeconds = int64(time.Since(startTime).Seconds())
+			}
+		}
+	}
+
+	return report
+}
+
+// DirSizes returns the sizes of the instance workdir and data directory
+func (instance Instance) DirSizes() (int64, int64) {
+	if !instance.State.Exists {
+		return 0, 0
+	}
+	dirSize, _ := utils.DirSizeBytes(instance.Config.Workdir)
+	// + "/" allows DirSizeBytes to follow symlink: data -> /path/to/actual/data + "/"
+	dataSize, _ := utils.DirSizeBytes(filepath.Join(instance.Config.Workdir, "data") + "/")
+	return dirSize, dataSize
+}
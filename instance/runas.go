@@ -0,0 +1,100 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+const (
+	runAsUserVar  = "RUN_AS_USER"
+	runAsGroupVar = "RUN_AS_GROUP"
+)
+
+// loadRunAs resolves RUN_AS_USER and RUN_AS_GROUP, nil when not set
+func loadRunAs(vars map[string]string) (*syscall.Credential, error) {
+	if vars[runAsUserVar] == "" {
+		if vars[runAsGroupVar] != "" {
+			errMsg := fmt.Sprintf("%s requires %s", runAsGroupVar, runAsUserVar)
+			return nil, errors.New(errMsg)
+		}
+		return nil, nil
+	}
+	return utils.LookupCredential(vars[runAsUserVar], vars[runAsGroupVar])
+}
+
+// spawnCredential returns the credential to start the process with. Only
+// root switches user, preflight makes sure others run as themselves.
+func (instance Instance) spawnCredential() *syscall.Credential {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	return instance.Config.Credential
+}
+
+// checkRunAs makes sure the user set by RUN_AS_USER can run the instance:
+// opsctl must be able to switch to it, and it must be able to write to the
+// workdir, log file and data dir and to execute the package binary.
+func (instance Instance) checkRunAs() error {
+	credential := instance.Config.Credential
+	if credential == nil {
+		return nil
+	}
+	runAs := instance.Config.RcValues[runAsUserVar]
+	if os.Geteuid() != 0 && uint32(os.Geteuid()) != credential.Uid {
+		errMsg := fmt.Sprintf("Running as %s requires opsctl to run as root or as %s", runAs, runAs)
+		return errors.New(errMsg)
+	}
+
+	writable := []string{
+		instance.Config.Workdir,
+		instance.LogPath(),
+		filepath.Join(instance.Config.Workdir, "data"),
+	}
+	for _, path := range writable {
+		permitted, err := utils.HasPermission(path, credential, utils.PermWrite)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil || !permitted {
+			errMsg := fmt.Sprintf("%s is not writable by %s", path, runAs)
+			return errors.New(errMsg)
+		}
+	}
+
+	executables := make([]string, 0)
+	if len(instance.Config.StartupArgs) > 0 {
+		executables = append(executables, instance.Config.StartupArgs[0])
+	}
+	// The process starts as opsctl itself, see utils.RunDetachedProcess
+	if self, err := os.Executable(); err == nil {
+		executables = append(executables, self)
+	}
+	for _, path := range executables {
+		permitted, err := utils.HasPermission(path, credential, utils.PermExecute)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil || !permitted {
+			errMsg := fmt.Sprintf("%s is not executable by %s", path, runAs)
+			return errors.New(errMsg)
+		}
+	}
+	return nil
+}
+
+// Owner returns the user the running instance process runs as
+func (instance Instance) Owner() string {
+	if !instance.State.Up {
+		return ""
+	}
+	owner, err := utils.ProcessOwner(instance.State.PID)
+	if err != nil {
+		return ""
+	}
+	return owner
+}
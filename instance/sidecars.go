@@ -0,0 +1,286 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+// Sidecars are declared in the rc file as:
+//
+//	SIDECARS=exporter
+//	SIDECAR_EXPORTER_CMD="/opt/exporter --port 9198"
+//	SIDECAR_EXPORTER_MATCH=".*/exporter --port 9198" # optional
+const (
+	sidecarsVar     = "SIDECARS"
+	sidecarPrefix   = "SIDECAR_"
+	sidecarCmdVar   = "_CMD"
+	sidecarMatchVar = "_MATCH"
+)
+
+var validSidecarName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Sidecar is a process running next to an instance, e.g. an exporter. It is
+// started once the instance is up and stopped before it goes down.
+type Sidecar struct {
+	Name        string
+	StartupArgs []string
+	// Regexes matching the running process, see RuntimeArgs of InstanceConfig
+	RuntimeArgs []string
+	// Rc variables of PortRcVars of InstanceConfig the sidecar listens on
+	PortRcVars []string
+}
+
+// SidecarDownError tells that a sidecar of a running instance is not running,
+// which makes the instance degraded but not down
+type SidecarDownError struct {
+	Name string
+}
+
+func (e SidecarDownError) Error() string {
+	return fmt.Sprintf("sidecar %s is not running", e.Name)
+}
+
+// loadSidecars parses SIDECARS and the SIDECAR_<NAME>_* variables
+func loadSidecars(vars map[string]string) ([]Sidecar, error) {
+	sidecars := make([]Sidecar, 0)
+	if vars[sidecarsVar] == "" {
+		return sidecars, nil
+	}
+	for _, name := range strings.Split(vars[sidecarsVar], ",") {
+		name = strings.TrimSpace(name)
+		if !validSidecarName.MatchString(name) {
+			errMsg := fmt.Sprintf("Invalid sidecar name '%s' in %s", name, sidecarsVar)
+			return nil, errors.New(errMsg)
+		}
+		prefix := sidecarPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		startupArgs, err := utils.SplitArgs(vars[prefix+sidecarCmdVar])
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid %s: %s", prefix+sidecarCmdVar, err)
+			return nil, errors.New(errMsg)
+		}
+		if len(startupArgs) == 0 {
+			errMsg := fmt.Sprintf("%s definition missing for sidecar %s", prefix+sidecarCmdVar, name)
+			return nil, errors.New(errMsg)
+		}
+		runtimeArgs, err := utils.SplitArgs(vars[prefix+sidecarMatchVar])
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid %s: %s", prefix+sidecarMatchVar, err)
+			return nil, errors.New(errMsg)
+		}
+		if len(runtimeArgs) == 0 {
+			runtimeArgs = utils.QuoteArgs(startupArgs)
+		}
+		sidecars = append(sidecars, Sidecar{
+			Name:        name,
+			StartupArgs: startupArgs,
+			RuntimeArgs: runtimeArgs,
+		})
+	}
+	return sidecars, nil
+}
+
+// AddSidecar declares a sidecar of the package, unless the rc file already
+// declares one with the same name
+func (instance *Instance) AddSidecar(sidecar Sidecar) {
+	for _, declared := range instance.Config.Sidecars {
+		if declared.Name == sidecar.Name {
+			return
+		}
+	}
+	if len(sidecar.RuntimeArgs) == 0 {
+		sidecar.RuntimeArgs = utils.QuoteArgs(sidecar.StartupArgs)
+	}
+	instance.Config.Sidecars = append(instance.Config.Sidecars, sidecar)
+}
+
+func (instance Instance) SidecarPidFilePath(sidecar Sidecar) string {
+	return filepath.Join(
+		instance.Config.Workdir,
+		fmt.Sprintf("%s-%s.pid", instance.Config.Type, sidecar.Name),
+	)
+}
+
+// SidecarLogPath is where the sidecar stdout and stderr are written
+func (instance Instance) SidecarLogPath(sidecar Sidecar) string {
+	return filepath.Join(
+		instance.Config.Workdir,
+		fmt.Sprintf("%s-%s.log", instance.Config.Type, sidecar.Name),
+	)
+}
+
+// SidecarIsUp works as IsUp, for a sidecar
+func (instance Instance) SidecarIsUp(sidecar Sidecar) (bool, int) {
+	pidFile, err := utils.ReadPidFile(instance.SidecarPidFilePath(sidecar))
+	if err == nil {
+		if pidFile.IsAlive() {
+			return true, pidFile.Pid
+		}
+		return false, -1
+	}
+	pids, _ := utils.GetMatchingPids(sidecar.RuntimeArgs)
+	if len(pids) == 1 {
+		return true, pids[0]
+	}
+	return false, -1
+}
+
+// checkSidecars returns a SidecarDownError for the first sidecar not running
+func (instance Instance) checkSidecars() error {
+	for _, sidecar := range instance.Config.Sidecars {
+		if up, _ := instance.SidecarIsUp(sidecar); !up {
+			return SidecarDownError{Name: sidecar.Name}
+		}
+	}
+	return nil
+}
+
+// StartSidecars starts the sidecars which are not running. A sidecar failing
+// to start leaves the instance degraded, so errors are only logged.
+func (instance Instance) StartSidecars() {
+	for _, sidecar := range instance.Config.Sidecars {
+		if up, pid := instance.SidecarIsUp(sidecar); up {
+			instance.LogMsg(fmt.Sprintf("sidecar %s already running with pid=%d", sidecar.Name, pid))
+			continue
+		}
+		spawnedPid, err := utils.RunDetachedProcess(instance.SidecarLogPath(sidecar), sidecar.StartupArgs, utils.SpawnOptions{
+			Credential: instance.spawnCredential(),
+			Env:        childEnvList(instance.ChildEnv()),
+		})
+		if err != nil {
+			instance.LogMsg(fmt.Sprintf("sidecar %s: %s", sidecar.Name, err))
+			continue
+		}
+		pid, err := utils.WaitForProcess(spawnedPid, sidecar.RuntimeArgs, instance.Config.StartupGracePeriod)
+		if err != nil {
+			instance.LogMsg(fmt.Sprintf("sidecar %s: %s", sidecar.Name, err))
+			continue
+		}
+		err = utils.WritePidFile(instance.SidecarPidFilePath(sidecar), pid)
+		if err != nil {
+			instance.LogMsg(err.Error())
+		}
+		instance.LogMsg(fmt.Sprintf("Started sidecar %s with pid=%d", sidecar.Name, pid))
+	}
+}
+
+// StopSidecars terminates the running sidecars, with the grace periods of
+// the instance. Errors are only logged, so that the instance still stops.
+func (instance Instance) StopSidecars() {
+	for _, sidecar := range instance.Config.Sidecars {
+		up, pid := instance.SidecarIsUp(sidecar)
+		if !up {
+			utils.RemovePidFile(instance.SidecarPidFilePath(sidecar))
+			continue
+		}
+		signal := instance.terminateSidecar(sidecar, pid)
+		if signal == "" {
+			instance.LogMsg(fmt.Sprintf("Failed to terminate sidecar %s pid=%d within grace period.", sidecar.Name, pid))
+			continue
+		}
+		utils.RemovePidFile(instance.SidecarPidFilePath(sidecar))
+		instance.LogMsg(fmt.Sprintf("Terminated sidecar %s pid=%d with %s", sidecar.Name, pid, signal))
+	}
+}
+
+// terminateSidecar returns the signal which stopped the sidecar, or an empty
+// string if it is still running
+func (instance Instance) terminateSidecar(sidecar Sidecar, pid int) string {
+	steps := []struct {
+		signal      syscall.Signal
+		name        string
+		gracePeriod time.Duration
+	}{
+		{syscall.SIGTERM, "SIGTERM", instance.Config.SigtermGracePeriod},
+		{syscall.SIGKILL, "SIGKILL", instance.Config.SigkillGracePeriod},
+	}
+	for _, step := range steps {
+		syscall.Kill(pid, step.signal)
+		for start := time.Now(); time.Since(start) < step.gracePeriod; {
+			time.Sleep(50 * time.Millisecond)
+			utils.RefreshProcTable()
+			if up, _ := instance.SidecarIsUp(sidecar); !up {
+				return step.name
+			}
+		}
+	}
+	return ""
+}
+
+// SidecarStatusRows returns the rows of sidecars nested under StatusRow
+func (instance Instance) SidecarStatusRows() [][]string {
+	rows := make([][]string, 0)
+	for _, sidecar := range instance.Config.Sidecars {
+		state, pid := instance.sidecarState(sidecar)
+		pidStr, owner := "", ""
+		if pid > 0 {
+			pidStr = fmt.Sprintf("%d", pid)
+			owner, _ = utils.ProcessOwner(pid)
+		}
+		rows = append(rows, []string{"", "└ " + sidecar.Name, "", state, "", pidStr, owner, "", ""})
+	}
+	return rows
+}
+
+// SidecarToolkitRows returns the rows of sidecars nested under ToolkitRow.
+// Row names must be unique, so they include the instance type and name.
+func (instance Instance) SidecarToolkitRows() [][]string {
+	rows := make([][]string, 0)
+	for _, sidecar := range instance.Config.Sidecars {
+		state, pid := instance.sidecarState(sidecar)
+		pidStr, ports, threads, startTimeStr, uptimeHours := "", "", "", "", ""
+		if pid > 0 {
+			pidStr = fmt.Sprintf("%d", pid)
+			if listening, err := utils.ListeningPorts(pid); err == nil {
+				ports = utils.JoinPorts(listening)
+			}
+			stat, err := utils.GetProcStats(pid)
+			if err == nil {
+				threads = fmt.Sprintf("%d", stat.NumThreads)
+				startEpoch, _ := stat.StartTime()
+				startTime := time.Unix(int64(startEpoch), 0)
+				startTimeStr = fmt.Sprintf("%s", startTime)
+				uptimeHours = fmt.Sprintf("%d", int(time.Since(startTime).Hours()))
+			}
+		}
+		rows = append(rows, []string{
+			fmt.Sprintf("%s - %s - %s", instance.Config.Type, instance.Config.Name, sidecar.Name),
+			state, "", ports, instance.Config.Type, "└ " + sidecar.Name,
+			startTimeStr, uptimeHours, pidStr, threads, "", "", "---",
+		})
+	}
+	return rows
+}
+
+// SidecarSummaries describes each sidecar for PrintSummary and Report
+func (instance Instance) SidecarSummaries() []SidecarReport {
+	summaries := make([]SidecarReport, 0)
+	for _, sidecar := range instance.Config.Sidecars {
+		state, pid := instance.sidecarState(sidecar)
+		summaries = append(summaries, SidecarReport{
+			Name:        sidecar.Name,
+			State:       state,
+			PID:         pid,
+			StartupArgs: sidecar.StartupArgs,
+			Log:         instance.SidecarLogPath(sidecar),
+		})
+	}
+	return summaries
+}
+
+// sidecarState returns UP, DOWN or DISABLED like StateName, and the pid if up
+func (instance Instance) sidecarState(sidecar Sidecar) (string, int) {
+	if up, pid := instance.SidecarIsUp(sidecar); up {
+		return "UP", pid
+	}
+	if !instance.State.Enabled {
+		return "DISABLED", 0
+	}
+	return "DOWN", 0
+}
@@ -0,0 +1,177 @@
+package instance
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+const (
+	// <workdir>/logstash.conf.tmpl renders to <workdir>/logstash.conf
+	templateSuffix = ".tmpl"
+	// The checksum of the last rendering is kept in <workdir>/.logstash.conf.rendered
+	// to tell hand-edited files apart
+	renderedSuffix = ".rendered"
+)
+
+// RenderedFile is a template of the workdir rendered in memory
+type RenderedFile struct {
+	Template string
+	Path     string
+	Content  []byte
+	// Content of the file on disk, nil if missing
+	Current []byte
+	// The file on disk was changed since it was last rendered
+	HandEdited bool
+	mode       os.FileMode
+}
+
+// Changed tells whether writing the file would change it
+func (file RenderedFile) Changed() bool {
+	return file.Current == nil || !bytes.Equal(file.Current, file.Content)
+}
+
+// Diff returns the changes writing the file would make, in unified format
+func (file RenderedFile) Diff() string {
+	current := "/dev/null"
+	if file.Current != nil {
+		current = file.Path
+	}
+	return utils.UnifiedDiff(current, file.Path+" (rendered)", string(file.Current), string(file.Content))
+}
+
+// TemplateData returns the values templates are rendered with: rc values,
+// plus Type, Name, Workdir, Home and Version
+func (instance Instance) TemplateData() map[string]string {
+	data := make(map[string]string)
+	for k, v := range instance.Config.RcValues {
+		data[k] = v
+	}
+	data["Type"] = instance.Config.Type
+	data["Name"] = instance.Config.Name
+	data["Workdir"] = instance.Config.Workdir
+	data["Home"] = instance.OpsctlEnv.Home
+	data["Version"] = instance.Config.RcValues[packageVersionVar]
+	return data
+}
+
+// Templates returns the *.tmpl files at the top of the workdir
+func (instance Instance) Templates() []string {
+	templates, err := filepath.Glob(filepath.Join(instance.Config.Workdir, "*"+templateSuffix))
+	if err != nil {
+		return []string{}
+	}
+	return templates
+}
+
+func renderedChecksumPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+renderedSuffix)
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// RenderTemplates renders the templates of the workdir, without writing them
+func (instance Instance) RenderTemplates() ([]RenderedFile, error) {
+	files := make([]RenderedFile, 0)
+	for _, templatePath := range instance.Templates() {
+		stat, err := os.Stat(templatePath)
+		if err != nil {
+			return files, err
+		}
+		text, err := ioutil.ReadFile(templatePath)
+		if err != nil {
+			return files, err
+		}
+		tmpl, err := template.New(filepath.Base(templatePath)).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid template %s: %s", templatePath, err)
+			return files, NewError(KindBadConfig, errors.New(errMsg))
+		}
+		var content bytes.Buffer
+		err = tmpl.Execute(&content, instance.TemplateData())
+		if err != nil {
+			errMsg := fmt.Sprintf("Unable to render %s: %s", templatePath, err)
+			return files, NewError(KindBadConfig, errors.New(errMsg))
+		}
+
+		file := RenderedFile{
+			Template: templatePath,
+			Path:     strings.TrimSuffix(templatePath, templateSuffix),
+			Content:  content.Bytes(),
+			mode:     stat.Mode().Perm(),
+		}
+		current, err := ioutil.ReadFile(file.Path)
+		if err == nil {
+			file.Current = current
+			// A file never rendered counts as hand-edited, unless it is up to date
+			lastRendered, err := ioutil.ReadFile(renderedChecksumPath(file.Path))
+			if err != nil {
+				file.HandEdited = file.Changed()
+			} else {
+				file.HandEdited = strings.TrimSpace(string(lastRendered)) != checksum(current)
+			}
+		} else if !os.IsNotExist(err) {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// WriteRendered writes a rendered file and records its checksum
+func (instance Instance) WriteRendered(file RenderedFile) error {
+	tmpPath := file.Path + ".tmp"
+	err := ioutil.WriteFile(tmpPath, file.Content, file.mode)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, file.Path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return ioutil.WriteFile(renderedChecksumPath(file.Path), []byte(checksum(file.Content)+"\n"), 0644)
+}
+
+// ApplyTemplates renders the templates of the workdir and writes the files
+// which changed. Hand-edited files are only overwritten when forced.
+func (instance Instance) ApplyTemplates(force bool) error {
+	files, err := instance.RenderTemplates()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.Changed() {
+			if file.HandEdited {
+				// Edited to the rendered content, take it over
+				err = instance.WriteRendered(file)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if file.HandEdited && !force {
+			errMsg := fmt.Sprintf("%s was edited by hand, see `opsctl render %s %s`", file.Path, instance.Config.Type, instance.Config.Name)
+			return NewError(KindBadConfig, errors.New(errMsg))
+		}
+		err = instance.WriteRendered(file)
+		if err != nil {
+			return err
+		}
+		instance.LogMsg(fmt.Sprintf("Rendered %s", file.Path))
+	}
+	return nil
+}
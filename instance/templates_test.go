@@ -0,0 +1,172 @@
+package instance
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func templateInstance(t *testing.T, port string) Instance {
+	return Instance{Config: InstanceConfig{
+		Type:     "logstash",
+		Name:     "ls1",
+		Workdir:  t.TempDir(),
+		RcValues: map[string]string{"LOGSTASH_PORT": port},
+	}}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestTemplatesHandEdits(t *testing.T) {
+	tests := []struct {
+		name string
+		// Prepares the workdir, the template renders "port=9600\n"
+		setup          func(t *testing.T, target Instance, path string)
+		wantChanged    bool
+		wantHandEdited bool
+		// ApplyTemplates(false) refuses to overwrite the file
+		wantApplyErr bool
+	}{
+		{
+			name:        "never rendered, missing",
+			setup:       func(t *testing.T, target Instance, path string) {},
+			wantChanged: true,
+		},
+		{
+			name: "never rendered, up to date",
+			setup: func(t *testing.T, target Instance, path string) {
+				writeFile(t, path, "port=9600\n")
+			},
+		},
+		{
+			name: "never rendered, different",
+			setup: func(t *testing.T, target Instance, path string) {
+				writeFile(t, path, "port=1\n")
+			},
+			wantChanged:    true,
+			wantHandEdited: true,
+			wantApplyErr:   true,
+		},
+		{
+			name: "rendered, up to date",
+			setup: func(t *testing.T, target Instance, path string) {
+				mustApply(t, target)
+			},
+		},
+		{
+			name: "rendered, then rc value changed",
+			setup: func(t *testing.T, target Instance, path string) {
+				previous := target
+				previous.Config.RcValues = map[string]string{"LOGSTASH_PORT": "9700"}
+				mustApply(t, previous)
+			},
+			wantChanged: true,
+		},
+		{
+			name: "rendered, then edited by hand",
+			setup: func(t *testing.T, target Instance, path string) {
+				mustApply(t, target)
+				writeFile(t, path, "port=1\n")
+			},
+			wantChanged:    true,
+			wantHandEdited: true,
+			wantApplyErr:   true,
+		},
+		{
+			name: "rendered, then edited by hand to the new rendering",
+			setup: func(t *testing.T, target Instance, path string) {
+				previous := target
+				previous.Config.RcValues = map[string]string{"LOGSTASH_PORT": "9700"}
+				mustApply(t, previous)
+				writeFile(t, path, "port=9600\n")
+			},
+			wantHandEdited: true,
+		},
+	}
+	for _, test := range tests {
+		target := templateInstance(t, "9600")
+		writeFile(t, filepath.Join(target.Config.Workdir, "logstash.conf.tmpl"), "port={{.LOGSTASH_PORT}}\n")
+		path := filepath.Join(target.Config.Workdir, "logstash.conf")
+		test.setup(t, target, path)
+		before, _ := ioutil.ReadFile(path)
+
+		files, err := target.RenderTemplates()
+		if err != nil {
+			t.Fatalf("%s: RenderTemplates() error = %v", test.name, err)
+		}
+		if len(files) != 1 || files[0].Path != path {
+			t.Fatalf("%s: RenderTemplates() = %v, want %s only", test.name, files, path)
+		}
+		if files[0].Changed() != test.wantChanged {
+			t.Errorf("%s: Changed() = %v, want %v", test.name, files[0].Changed(), test.wantChanged)
+		}
+		if files[0].HandEdited != test.wantHandEdited {
+			t.Errorf("%s: HandEdited = %v, want %v", test.name, files[0].HandEdited, test.wantHandEdited)
+		}
+
+		err = target.ApplyTemplates(false)
+		if (err != nil) != test.wantApplyErr {
+			t.Errorf("%s: ApplyTemplates(false) error = %v, wantErr %v", test.name, err, test.wantApplyErr)
+		}
+		if test.wantApplyErr {
+			if got, _ := ioutil.ReadFile(path); string(got) != string(before) {
+				t.Errorf("%s: ApplyTemplates(false) overwrote a hand-edited file", test.name)
+			}
+			mustApplyForced(t, target)
+		}
+		if got := readFile(t, path); got != "port=9600\n" {
+			t.Errorf("%s: file content = %q after apply", test.name, got)
+		}
+
+		// Once applied, the file is up to date and owned by opsctl
+		files, err = target.RenderTemplates()
+		if err != nil {
+			t.Fatalf("%s: RenderTemplates() error = %v", test.name, err)
+		}
+		if files[0].Changed() || files[0].HandEdited {
+			t.Errorf("%s: after apply, Changed() = %v, HandEdited = %v", test.name, files[0].Changed(), files[0].HandEdited)
+		}
+	}
+}
+
+func mustApply(t *testing.T, target Instance) {
+	if err := target.ApplyTemplates(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustApplyForced(t *testing.T, target Instance) {
+	if err := target.ApplyTemplates(true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemplatesRenderErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"invalid template", "port={{.LOGSTASH_PORT\n"},
+		{"missing value", "port={{.UNDEFINED_PORT}}\n"},
+	}
+	for _, test := range tests {
+		target := templateInstance(t, "9600")
+		writeFile(t, filepath.Join(target.Config.Workdir, "logstash.conf.tmpl"), test.template)
+		if _, err := target.RenderTemplates(); err == nil {
+			t.Errorf("%s: RenderTemplates() succeeded", test.name)
+		}
+	}
+}
@@ -6,44 +6,79 @@ import (
 	"time"
 
 	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/utils"
 )
 
 var mandatoryRcVars = []string{
 	"LOGSTASH_HTTP_API_PORT",
 }
 
+// Port of the logstash_exporter sidecar, started only when set
+const exporterPortVar = "LOGSTASH_EXPORTER_PORT"
+
+var optionalRcVars = []string{
+	exporterPortVar,
+}
+
+// Rc variables holding ports the service and its sidecars listen on
+var portRcVars = []string{
+	"LOGSTASH_HTTP_API_PORT",
+	exporterPortVar,
+}
+
+// Files and directories used by the startup command
+var workdirLayout = instance.WorkdirLayout{
+	Dirs:  []string{"data", "logs"},
+	Files: []string{"logstash.conf"},
+}
+
+// Logs written by logstash itself, besides its stdout
+var logFiles = []string{
+	"logs/*.log",
+}
+
 type Logstash struct {
 	Instance instance.Instance
 }
 
 func (svc Logstash) Self() instance.Instance {
 	svc.Instance.Config.MandatoryRcVars = mandatoryRcVars
+	svc.Instance.Config.OptionalRcVars = optionalRcVars
+	svc.Instance.Config.PortRcVars = portRcVars
+	svc.Instance.Config.StartupGracePeriod = 10 * time.Second
+	svc.Instance.Config.SigtermGracePeriod = 10 * time.Second
+	svc.Instance.Config.SigkillGracePeriod = 10 * time.Second
+	svc.Instance.Config.Layout = workdirLayout
+	svc.Instance.Config.LogFiles = logFiles
 	return svc.Instance
 }
 
-func (svc Logstash) Start() {
+func (svc Logstash) Start() error {
 	instance := svc.Instance
+	var err error
 	if !instance.State.Up {
 		instance.LogMsg("starting")
-		startupGracePeriod := 10 * time.Second
-		instance.RunInstanceProcess(startupGracePeriod)
+		err = instance.RunInstanceProcess(instance.Config.StartupGracePeriod)
 	} else {
 		instance.LogMsg("already running")
 	}
-
-	// TODO : logstash_exporter sidecar startup
+	if err == nil {
+		instance.StartSidecars()
+	}
+	return err
 }
 
-func (svc Logstash) Stop() {
+func (svc Logstash) Stop() error {
 	instance := svc.Instance
+	var err error
+	instance.StopSidecars()
 	if instance.State.Up {
 		instance.LogMsg("stopping")
-		sigtermGracePeriod := 10 * time.Second
-		sigkillGracePeriod := 10 * time.Second
-		instance.TerminateInstanceProcess(sigtermGracePeriod, sigkillGracePeriod)
+		err = instance.TerminateInstanceProcess(instance.Config.SigtermGracePeriod, instance.Config.SigkillGracePeriod)
 	} else {
 		instance.LogMsg("already stopped")
 	}
+	return err
 }
 
 func (svc *Logstash) SetStartupCmd() {
@@ -72,6 +107,49 @@ func (svc *Logstash) SetStartupCmd() {
 }
 
 func (svc *Logstash) SetRuntimeCmd() {
-	runtimeArgs := append([]string{"bin/java .*"}, svc.Instance.Config.StartupArgs[1:]...)
+	// bin/logstash execs java, which receives the logstash options after its own
+	runtimeArgs := append([]string{".*/bin/java"}, utils.QuoteArgs(svc.Instance.Config.StartupArgs[1:])...)
 	svc.Instance.Config.RuntimeArgs = runtimeArgs
 }
+
+// Defines readiness and liveness probes
+// The HTTP API only comes up once the JVM and pipelines are initialized.
+func (svc *Logstash) SetProbes() {
+	instance := svc.Instance
+	probes := []utils.Probe{
+		{
+			Type:    utils.ProbeHTTP,
+			URL:     fmt.Sprintf("http://127.0.0.1:%s/_node", instance.Config.RcValues["LOGSTASH_HTTP_API_PORT"]),
+			Timeout: instance.ProbeTimeout(),
+		},
+	}
+	svc.Instance.Config.ReadinessProbes = probes
+	svc.Instance.Config.LivenessProbes = probes
+	svc.Instance.Config.ReadinessTimeout = 120 * time.Second
+}
+
+// Defines sidecars besides those of the rc file
+// logstash_exporter scrapes the HTTP API, from the logstash_exporter package.
+func (svc *Logstash) SetSidecars() {
+	rcValues := svc.Instance.Config.RcValues
+	port := rcValues[exporterPortVar]
+	if port == "" {
+		return
+	}
+	exporterBin := filepath.Join(
+		svc.Instance.OpsctlEnv.Home,
+		"packages",
+		"logstash_exporter",
+		"active_prod",
+		"logstash_exporter",
+	)
+	svc.Instance.AddSidecar(instance.Sidecar{
+		Name: "logstash_exporter",
+		StartupArgs: []string{
+			exporterBin,
+			fmt.Sprintf("--logstash.endpoint=http://127.0.0.1:%s", rcValues["LOGSTASH_HTTP_API_PORT"]),
+			fmt.Sprintf("--web.listen-address=:%s", port),
+		},
+		PortRcVars: []string{exporterPortVar},
+	})
+}
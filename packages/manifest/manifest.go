@@ -0,0 +1,342 @@
+package manifest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/utils"
+	"gopkg.in/yaml.v2"
+)
+
+const manifestFilename = "package.yaml"
+
+// Manifest declares a package type in $OPSCTL_HOME/packages/<type>/package.yaml
+// Values of Binary, Args, RuntimeArgs and probes are Go templates, executed with
+// the instance rc values as well as .Type, .Name, .Workdir, .Home and .Version
+//
+// Example:
+//
+//	binary: bin/myservice
+//	args: ["--port", "{{.MYSERVICE_PORT}}", "--data", "{{.Workdir}}/data"]
+//	mandatory_rc_vars: [MYSERVICE_PORT]
+//	port_rc_vars: [MYSERVICE_PORT]
+//	startup_grace_period: 2s
+//	probes:
+//	  - type: tcp
+//	    address: "127.0.0.1:{{.MYSERVICE_PORT}}"
+//	sidecars:
+//	  - name: exporter
+//	    command: ["{{.Home}}/bin/myservice_exporter", "--port", "{{.EXPORTER_PORT}}"]
+type Manifest struct {
+	// Path of the binary, relative to packages/<type>/<version>/
+	Binary          string   `yaml:"binary"`
+	Args            []string `yaml:"args"`
+	MandatoryRcVars []string `yaml:"mandatory_rc_vars"`
+	// Rc variables holding ports the service listens on
+	PortRcVars []string `yaml:"port_rc_vars"`
+	// Regexes matching the running process, see SetRuntimeCmd of compiled-in
+	// packages. Defaults to the startup command.
+	RuntimeArgs        []string        `yaml:"runtime_args"`
+	StartupGracePeriod string          `yaml:"startup_grace_period"`
+	SigtermGracePeriod string          `yaml:"sigterm_grace_period"`
+	SigkillGracePeriod string          `yaml:"sigkill_grace_period"`
+	ReadinessTimeout   string          `yaml:"readiness_timeout"`
+	Probes             []ManifestProbe `yaml:"probes"`
+	// Directories and files created in the workdir by `opsctl create`
+	Layout ManifestLayout `yaml:"layout"`
+	// Logs written by the service itself, relative to the workdir
+	LogFiles []string          `yaml:"log_files"`
+	Sidecars []ManifestSidecar `yaml:"sidecars"`
+
+	startupGracePeriod time.Duration
+	sigtermGracePeriod time.Duration
+	sigkillGracePeriod time.Duration
+	readinessTimeout   time.Duration
+}
+
+type ManifestLayout struct {
+	Dirs  []string `yaml:"dirs"`
+	Files []string `yaml:"files"`
+}
+
+// ManifestProbe is used for both readiness and liveness, see utils.Probe
+type ManifestProbe struct {
+	Type    string   `yaml:"type"`
+	Address string   `yaml:"address"`
+	URL     string   `yaml:"url"`
+	Command []string `yaml:"command"`
+}
+
+// ManifestSidecar is a process started next to each instance, see instance.Sidecar
+type ManifestSidecar struct {
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command"`
+	// Defaults to the command
+	RuntimeArgs []string `yaml:"runtime_args"`
+}
+
+var (
+	manifestsMutex sync.Mutex
+	manifests      = make(map[string]Manifest)
+)
+
+func ManifestPath(home string, packageType string) string {
+	return filepath.Join(home, "packages", packageType, manifestFilename)
+}
+
+func Exists(home string, packageType string) bool {
+	stat, err := os.Stat(ManifestPath(home, packageType))
+	return err == nil && !stat.IsDir()
+}
+
+// Load reads and validates the manifest of a package type, once per command
+func Load(home string, packageType string) (Manifest, error) {
+	path := ManifestPath(home, packageType)
+	manifestsMutex.Lock()
+	defer manifestsMutex.Unlock()
+	if manifest, found := manifests[path]; found {
+		return manifest, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to read package manifest %s", path)
+		return Manifest{}, errors.New(errMsg)
+	}
+	manifest := Manifest{}
+	err = yaml.UnmarshalStrict(content, &manifest)
+	if err != nil {
+		errMsg := fmt.Sprintf("Invalid package manifest %s: %s", path, err)
+		return Manifest{}, errors.New(errMsg)
+	}
+	if manifest.Binary == "" {
+		errMsg := fmt.Sprintf("Invalid package manifest %s: binary is mandatory", path)
+		return Manifest{}, errors.New(errMsg)
+	}
+	for _, sidecar := range manifest.Sidecars {
+		if sidecar.Name == "" || len(sidecar.Command) == 0 {
+			errMsg := fmt.Sprintf("Invalid package manifest %s: sidecars need a name and a command", path)
+			return Manifest{}, errors.New(errMsg)
+		}
+	}
+
+	durations := []struct {
+		name         string
+		value        string
+		defaultValue time.Duration
+		target       *time.Duration
+	}{
+		{"startup_grace_period", manifest.StartupGracePeriod, 2 * time.Second, &manifest.startupGracePeriod},
+		{"sigterm_grace_period", manifest.SigtermGracePeriod, 5 * time.Second, &manifest.sigtermGracePeriod},
+		{"sigkill_grace_period", manifest.SigkillGracePeriod, 5 * time.Second, &manifest.sigkillGracePeriod},
+		{"readiness_timeout", manifest.ReadinessTimeout, 10 * time.Second, &manifest.readinessTimeout},
+	}
+	for _, d := range durations {
+		*d.target = d.defaultValue
+		if d.value == "" {
+			continue
+		}
+		*d.target, err = time.ParseDuration(d.value)
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid %s '%s' in package manifest %s", d.name, d.value, path)
+			return Manifest{}, errors.New(errMsg)
+		}
+	}
+
+	for _, probe := range manifest.Probes {
+		switch probe.Type {
+		case utils.ProbeTCP, utils.ProbeHTTP, utils.ProbeExec:
+		default:
+			errMsg := fmt.Sprintf("Unsupported probe type '%s' in package manifest %s", probe.Type, path)
+			return Manifest{}, errors.New(errMsg)
+		}
+	}
+
+	manifests[path] = manifest
+	return manifest, nil
+}
+
+// ManifestPackage implements a package type from its manifest
+type ManifestPackage struct {
+	Instance instance.Instance
+	Manifest Manifest
+}
+
+func (svc ManifestPackage) Self() instance.Instance {
+	svc.Instance.Config.MandatoryRcVars = svc.Manifest.MandatoryRcVars
+	svc.Instance.Config.PortRcVars = svc.Manifest.PortRcVars
+	svc.Instance.Config.StartupGracePeriod = svc.Manifest.startupGracePeriod
+	svc.Instance.Config.SigtermGracePeriod = svc.Manifest.sigtermGracePeriod
+	svc.Instance.Config.SigkillGracePeriod = svc.Manifest.sigkillGracePeriod
+	svc.Instance.Config.Layout = instance.WorkdirLayout{
+		Dirs:  svc.Manifest.Layout.Dirs,
+		Files: svc.Manifest.Layout.Files,
+	}
+	svc.Instance.Config.LogFiles = svc.Manifest.LogFiles
+	return svc.Instance
+}
+
+func (svc ManifestPackage) Start() error {
+	instance := svc.Instance
+	var err error
+	if !instance.State.Up {
+		instance.LogMsg("starting")
+		err = instance.RunInstanceProcess(instance.Config.StartupGracePeriod)
+	} else {
+		instance.LogMsg("already running")
+	}
+	if err == nil {
+		instance.StartSidecars()
+	}
+	return err
+}
+
+func (svc ManifestPackage) Stop() error {
+	instance := svc.Instance
+	var err error
+	instance.StopSidecars()
+	if instance.State.Up {
+		instance.LogMsg("stopping")
+		err = instance.TerminateInstanceProcess(instance.Config.SigtermGracePeriod, instance.Config.SigkillGracePeriod)
+	} else {
+		instance.LogMsg("already stopped")
+	}
+	return err
+}
+
+// Defines the startup command
+func (svc *ManifestPackage) SetStartupCmd() {
+	instance := svc.Instance
+	binary, err := svc.render(svc.Manifest.Binary)
+	if err != nil {
+		svc.setConfigError(err)
+		return
+	}
+	// Determine path of package binary
+	serviceBin := filepath.Join(
+		instance.OpsctlEnv.Home,
+		"packages",
+		instance.Config.Type,
+		instance.Config.RcValues["INSTANCE_PACKAGE_VERSION"],
+		binary,
+	)
+	args, err := svc.renderAll(svc.Manifest.Args)
+	if err != nil {
+		svc.setConfigError(err)
+		return
+	}
+	svc.Instance.Config.StartupArgs = append([]string{serviceBin}, args...)
+}
+
+// Defines the runtime pattern, the startup command unless set in the manifest
+func (svc *ManifestPackage) SetRuntimeCmd() {
+	if len(svc.Manifest.RuntimeArgs) == 0 {
+		svc.Instance.Config.RuntimeArgs = utils.QuoteArgs(svc.Instance.Config.StartupArgs)
+		return
+	}
+	runtimeArgs, err := svc.renderAll(svc.Manifest.RuntimeArgs)
+	if err != nil {
+		svc.setConfigError(err)
+		return
+	}
+	svc.Instance.Config.RuntimeArgs = runtimeArgs
+}
+
+// Defines readiness and liveness probes
+func (svc *ManifestPackage) SetProbes() {
+	probes := make([]utils.Probe, 0)
+	for _, manifestProbe := range svc.Manifest.Probes {
+		address, err := svc.render(manifestProbe.Address)
+		if err != nil {
+			svc.setConfigError(err)
+			return
+		}
+		url, err := svc.render(manifestProbe.URL)
+		if err != nil {
+			svc.setConfigError(err)
+			return
+		}
+		command, err := svc.renderAll(manifestProbe.Command)
+		if err != nil {
+			svc.setConfigError(err)
+			return
+		}
+		probes = append(probes, utils.Probe{
+			Type:    manifestProbe.Type,
+			Address: address,
+			URL:     url,
+			Command: command,
+			Timeout: svc.Instance.ProbeTimeout(),
+		})
+	}
+	svc.Instance.Config.ReadinessProbes = probes
+	svc.Instance.Config.LivenessProbes = probes
+	svc.Instance.Config.ReadinessTimeout = svc.Manifest.readinessTimeout
+}
+
+// Defines sidecars besides those of the rc file
+func (svc *ManifestPackage) SetSidecars() {
+	for _, manifestSidecar := range svc.Manifest.Sidecars {
+		command, err := svc.renderAll(manifestSidecar.Command)
+		if err != nil {
+			svc.setConfigError(err)
+			return
+		}
+		runtimeArgs, err := svc.renderAll(manifestSidecar.RuntimeArgs)
+		if err != nil {
+			svc.setConfigError(err)
+			return
+		}
+		svc.Instance.AddSidecar(instance.Sidecar{
+			Name:        manifestSidecar.Name,
+			StartupArgs: command,
+			RuntimeArgs: runtimeArgs,
+		})
+	}
+}
+
+func (svc ManifestPackage) render(text string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		errMsg := fmt.Sprintf("Invalid template '%s' in package manifest: %s", text, err)
+		return "", errors.New(errMsg)
+	}
+	var out bytes.Buffer
+	err = tmpl.Execute(&out, svc.Instance.TemplateData())
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to render '%s' from package manifest: %s", text, err)
+		return "", errors.New(errMsg)
+	}
+	return out.String(), nil
+}
+
+func (svc ManifestPackage) renderAll(texts []string) ([]string, error) {
+	rendered := make([]string, 0, len(texts))
+	for _, text := range texts {
+		value, err := svc.render(text)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, value)
+	}
+	return rendered, nil
+}
+
+// Keeps the first configuration error, e.g. from loading the rc file
+func (svc *ManifestPackage) setConfigError(err error) {
+	if svc.Instance.Errors.Config == nil {
+		svc.Instance.Errors.Config = instance.NewError(instance.KindBadConfig, err)
+	}
+}
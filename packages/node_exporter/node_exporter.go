@@ -6,42 +6,57 @@ import (
 	"time"
 
 	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/utils"
 )
 
 var mandatoryRcVars = []string{
 	"NODE_EXPORTER_LISTEN_PORT",
 }
 
+// Rc variables holding ports the service listens on
+var portRcVars = []string{
+	"NODE_EXPORTER_LISTEN_PORT",
+}
+
 type NodeExporter struct {
 	Instance instance.Instance
 }
 
 func (svc NodeExporter) Self() instance.Instance {
 	svc.Instance.Config.MandatoryRcVars = mandatoryRcVars
+	svc.Instance.Config.PortRcVars = portRcVars
+	svc.Instance.Config.StartupGracePeriod = 2 * time.Second
+	svc.Instance.Config.SigtermGracePeriod = 5 * time.Second
+	svc.Instance.Config.SigkillGracePeriod = 5 * time.Second
 	return svc.Instance
 }
 
-func (svc NodeExporter) Start() {
+func (svc NodeExporter) Start() error {
 	instance := svc.Instance
+	var err error
 	if !instance.State.Up {
 		instance.LogMsg("starting")
-		startupGracePeriod := 2 * time.Second
-		instance.RunInstanceProcess(startupGracePeriod)
+		err = instance.RunInstanceProcess(instance.Config.StartupGracePeriod)
 	} else {
 		instance.LogMsg("already running")
 	}
+	if err == nil {
+		instance.StartSidecars()
+	}
+	return err
 }
 
-func (svc NodeExporter) Stop() {
+func (svc NodeExporter) Stop() error {
 	instance := svc.Instance
+	var err error
+	instance.StopSidecars()
 	if instance.State.Up {
 		instance.LogMsg("stopping")
-		sigtermGracePeriod := 5 * time.Second
-		sigkillGracePeriod := 5 * time.Second
-		instance.TerminateInstanceProcess(sigtermGracePeriod, sigkillGracePeriod)
+		err = instance.TerminateInstanceProcess(instance.Config.SigtermGracePeriod, instance.Config.SigkillGracePeriod)
 	} else {
 		instance.LogMsg("already stopped")
 	}
+	return err
 }
 
 // Defines the startup command
@@ -70,8 +85,28 @@ func (svc *NodeExporter) SetStartupCmd() {
 
 // Defines the runtime pattern if different from startup command
 // Example: logstash is started with 'bin/logstash' but process runtime is 'bin/java'
-// Must return an array of arguments. Regex can be used, example:
-// []string{"java.*","something", "--some-option", "value"}
+// Must return an array of arguments, each one being a regex matching a whole
+// argument. The first one matches the executable, the others must appear in
+// the same order. Example:
+// []string{".*/bin/java", "something", "--some-option", "value"}
 func (svc *NodeExporter) SetRuntimeCmd() {
-	svc.Instance.Config.RuntimeArgs = svc.Instance.Config.StartupArgs
+	svc.Instance.Config.RuntimeArgs = utils.QuoteArgs(svc.Instance.Config.StartupArgs)
 }
+
+// Defines readiness and liveness probes
+func (svc *NodeExporter) SetProbes() {
+	instance := svc.Instance
+	probes := []utils.Probe{
+		{
+			Type:    utils.ProbeHTTP,
+			URL:     fmt.Sprintf("http://127.0.0.1:%s/metrics", instance.Config.RcValues["NODE_EXPORTER_LISTEN_PORT"]),
+			Timeout: instance.ProbeTimeout(),
+		},
+	}
+	svc.Instance.Config.ReadinessProbes = probes
+	svc.Instance.Config.LivenessProbes = probes
+	svc.Instance.Config.ReadinessTimeout = 10 * time.Second
+}
+
+// Defines sidecars besides those of the rc file
+func (svc *NodeExporter) SetSidecars() {}
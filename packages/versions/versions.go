@@ -0,0 +1,274 @@
+package versions
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/f4t/opsctl/utils"
+)
+
+// Installed versions of a package type live in $OPSCTL_HOME/packages/<type>/<version>/
+// Aliases such as active_prod are symlinks to a version, which instances pick
+// through INSTANCE_PACKAGE_VERSION.
+const DefaultAlias = "active_prod"
+
+type Version struct {
+	Type string
+	Name string
+	// Set for aliases only
+	Target string
+	// Aliases pointing to this version
+	Aliases []string
+}
+
+func (version Version) IsAlias() bool {
+	return version.Target != ""
+}
+
+func TypeDir(home string, packageType string) string {
+	return filepath.Join(home, "packages", packageType)
+}
+
+func validateName(kind string, name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\x00") {
+		errMsg := fmt.Sprintf("Invalid %s name '%s'", kind, name)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+func historyPath(home string, packageType string, alias string) string {
+	return filepath.Join(TypeDir(home, packageType), fmt.Sprintf(".%s.history", alias))
+}
+
+// Types returns all package types having a directory under $OPSCTL_HOME/packages
+func Types(home string) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(home, "packages"))
+	if err != nil {
+		return nil, err
+	}
+	types := make([]string, 0)
+	for _, f := range files {
+		if f.IsDir() {
+			types = append(types, f.Name())
+		}
+	}
+	return types, nil
+}
+
+// List returns versions and aliases of a package type
+func List(home string, packageType string) ([]Version, error) {
+	typeDir := TypeDir(home, packageType)
+	files, err := ioutil.ReadDir(typeDir)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]Version, 0)
+	aliases := make(map[string][]string)
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		version := Version{Type: packageType, Name: f.Name()}
+		if f.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(filepath.Join(typeDir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			version.Target = target
+			aliases[target] = append(aliases[target], f.Name())
+		} else if !f.IsDir() {
+			// e.g. package.yaml
+			continue
+		}
+		versions = append(versions, version)
+	}
+	for i, version := range versions {
+		if !version.IsAlias() {
+			versions[i].Aliases = aliases[version.Name]
+			sort.Strings(versions[i].Aliases)
+		}
+	}
+	return versions, nil
+}
+
+// Install extracts a .tar.gz package into a new version directory. A single
+// top-level directory in the archive (e.g. logstash-7.10.2/) is stripped.
+func Install(home string, packageType string, version string, tarball string) (string, error) {
+	err := validateName("package type", packageType)
+	if err != nil {
+		return "", err
+	}
+	err = validateName("version", version)
+	if err != nil {
+		return "", err
+	}
+	typeDir := TypeDir(home, packageType)
+	versionDir := filepath.Join(typeDir, version)
+	if _, err := os.Lstat(versionDir); err == nil {
+		errMsg := fmt.Sprintf("%s already exists", versionDir)
+		return "", errors.New(errMsg)
+	}
+	err = os.MkdirAll(typeDir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	// Extract next to the final directory, so that the version only shows up complete
+	tmpDir, err := ioutil.TempDir(typeDir, fmt.Sprintf(".%s.install-", version))
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	err = utils.ExtractTarGz(tarball, tmpDir)
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed extracting %s: %s", tarball, err)
+		return "", errors.New(errMsg)
+	}
+
+	root := tmpDir
+	entries, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		root = filepath.Join(tmpDir, entries[0].Name())
+	}
+	err = os.Chmod(root, 0755)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(root, versionDir)
+	if err != nil {
+		return "", err
+	}
+	return versionDir, nil
+}
+
+// Activate points alias to version, replacing the symlink atomically.
+// Returns the version the alias pointed to before, if any.
+func Activate(home string, packageType string, version string, alias string) (string, error) {
+	err := validateName("package type", packageType)
+	if err != nil {
+		return "", err
+	}
+	previous, err := swapAlias(home, packageType, version, alias)
+	if err != nil {
+		return "", err
+	}
+	if previous != "" && previous != version {
+		err = appendHistory(home, packageType, alias, previous)
+	}
+	return previous, err
+}
+
+// Rollback points alias back to the version it pointed to before the last
+// activation. Returns the version rolled back to.
+func Rollback(home string, packageType string, alias string) (string, error) {
+	err := validateName("package type", packageType)
+	if err != nil {
+		return "", err
+	}
+	err = validateName("alias", alias)
+	if err != nil {
+		return "", err
+	}
+	history, err := readHistory(home, packageType, alias)
+	if err != nil {
+		return "", err
+	}
+	if len(history) == 0 {
+		errMsg := fmt.Sprintf("No previous version recorded for %s/%s", packageType, alias)
+		return "", errors.New(errMsg)
+	}
+	version := history[len(history)-1]
+	_, err = swapAlias(home, packageType, version, alias)
+	if err != nil {
+		return "", err
+	}
+	return version, writeHistory(home, packageType, alias, history[:len(history)-1])
+}
+
+func swapAlias(home string, packageType string, version string, alias string) (string, error) {
+	err := validateName("version", version)
+	if err != nil {
+		return "", err
+	}
+	err = validateName("alias", alias)
+	if err != nil {
+		return "", err
+	}
+	typeDir := TypeDir(home, packageType)
+
+	stat, err := os.Lstat(filepath.Join(typeDir, version))
+	if err != nil || !stat.IsDir() {
+		errMsg := fmt.Sprintf("Version %s of %s is not installed", version, packageType)
+		return "", errors.New(errMsg)
+	}
+
+	aliasPath := filepath.Join(typeDir, alias)
+	previous := ""
+	stat, err = os.Lstat(aliasPath)
+	if err == nil {
+		if stat.Mode()&os.ModeSymlink == 0 {
+			errMsg := fmt.Sprintf("%s exists and is not a symlink", aliasPath)
+			return "", errors.New(errMsg)
+		}
+		previous, err = os.Readlink(aliasPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// rename(2) replaces the alias atomically: instances never see it missing
+	tmpLink := filepath.Join(typeDir, fmt.Sprintf(".%s.tmp", alias))
+	os.Remove(tmpLink)
+	err = os.Symlink(version, tmpLink)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(tmpLink, aliasPath)
+	if err != nil {
+		os.Remove(tmpLink)
+		return "", err
+	}
+	return previous, nil
+}
+
+func readHistory(home string, packageType string, alias string) ([]string, error) {
+	content, err := ioutil.ReadFile(historyPath(home, packageType, alias))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	history := make([]string, 0)
+	for _, line := range strings.Split(string(content), "\n") {
+		if line != "" {
+			history = append(history, line)
+		}
+	}
+	return history, nil
+}
+
+func writeHistory(home string, packageType string, alias string, history []string) error {
+	content := ""
+	for _, version := range history {
+		content += version + "\n"
+	}
+	return ioutil.WriteFile(historyPath(home, packageType, alias), []byte(content), 0644)
+}
+
+func appendHistory(home string, packageType string, alias string, version string) error {
+	history, err := readHistory(home, packageType, alias)
+	if err != nil {
+		return err
+	}
+	return writeHistory(home, packageType, alias, append(history, version))
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/f4t/opsctl/instance"
+)
+
+// DependencyOrder returns all instances in start order, grouped in layers of
+// instances which do not depend on each other. Stops use the reverse order.
+func DependencyOrder() ([][]instance.InstanceRef, error) {
+	return DependencyOrderOf(instance.DiscoverAllInstances())
+}
+
+// DependencyOrderOf sorts some instances like DependencyOrder. Dependencies
+// on instances which are not part of refs are ignored.
+func DependencyOrderOf(refs []instance.InstanceRef) ([][]instance.InstanceRef, error) {
+	known := make(map[instance.InstanceRef]bool)
+	for _, ref := range instance.DiscoverAllInstances() {
+		known[ref] = true
+	}
+
+	dependencies := make(map[instance.InstanceRef][]instance.InstanceRef)
+	for _, ref := range refs {
+		svc, err := MakeInstance(ref.Type, ref.Name)
+		if err != nil {
+			continue
+		}
+		for _, dep := range svc.Self().Config.Dependencies {
+			if !known[dep] {
+				log.Printf("%s depends on unknown instance %s, ignoring", ref, dep)
+				continue
+			}
+			dependencies[ref] = append(dependencies[ref], dep)
+		}
+	}
+	return instance.DependencyLayers(refs, dependencies)
+}
+
+// DependenciesOf returns the direct and indirect dependencies of an instance,
+// in start order.
+func DependenciesOf(ref instance.InstanceRef) ([][]instance.InstanceRef, error) {
+	refs := make([]instance.InstanceRef, 0)
+	dependencies := make(map[instance.InstanceRef][]instance.InstanceRef)
+	seen := map[instance.InstanceRef]bool{ref: true}
+	queue := []instance.InstanceRef{ref}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		svc, err := MakeInstance(current.Type, current.Name)
+		if err != nil {
+			return nil, err
+		}
+		if current != ref && !svc.Self().State.Exists {
+			errMsg := fmt.Sprintf("%s depends on unknown instance %s", ref, current)
+			return nil, errors.New(errMsg)
+		}
+		dependencies[current] = svc.Self().Config.Dependencies
+		for _, dep := range svc.Self().Config.Dependencies {
+			if !seen[dep] {
+				seen[dep] = true
+				refs = append(refs, dep)
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	// The instance itself takes part in sorting to detect cycles through it
+	layers, err := instance.DependencyLayers(append(refs, ref), dependencies)
+	if err != nil {
+		return nil, err
+	}
+	// Drop the instance itself, alone in the last layer
+	return layers[:len(layers)-1], nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/f4t/opsctl/instance"
+	"github.com/f4t/opsctl/utils"
+)
+
+// Preflight runs the instance checks, then makes sure its ports are free
+func Preflight(svc ServiceInterface) error {
+	instance := svc.Self()
+	err := instance.Preflight()
+	if err != nil {
+		return err
+	}
+	err = CheckPorts(instance)
+	if err != nil {
+		instance.LogMsg(err.Error())
+	}
+	return err
+}
+
+// portClaim is a port an enabled instance is configured to listen on
+type portClaim struct {
+	ref     instance.InstanceRef
+	portVar string
+}
+
+var (
+	portClaimsMutex sync.Mutex
+	portClaims      map[int][]portClaim
+)
+
+// loadPortClaims returns the ports of all enabled instances. They only depend
+// on rc files and enabled flags, so they are read once per opsctl run rather
+// than once per preflight.
+func loadPortClaims() map[int][]portClaim {
+	portClaimsMutex.Lock()
+	defer portClaimsMutex.Unlock()
+	if portClaims != nil {
+		return portClaims
+	}
+	claims := make(map[int][]portClaim)
+	for _, ref := range instance.DiscoverAllInstances() {
+		svc, err := MakeInstance(ref.Type, ref.Name)
+		if err != nil {
+			continue
+		}
+		other := svc.Self()
+		if !other.State.Enabled {
+			continue
+		}
+		otherPorts, _ := other.Ports()
+		for v, port := range otherPorts {
+			claims[port] = append(claims[port], portClaim{ref: ref, portVar: v})
+		}
+	}
+	portClaims = claims
+	return portClaims
+}
+
+// CheckPorts fails when a port of the instance is claimed by another enabled
+// instance, or listened on by a process other than the instance itself.
+// Errors are of kind KindBadConfig.
+func CheckPorts(self instance.Instance) error {
+	return instance.NewError(instance.KindBadConfig, checkPorts(self))
+}
+
+func checkPorts(self instance.Instance) error {
+	ports, err := self.Ports()
+	if err != nil {
+		return err
+	}
+	if len(ports) == 0 {
+		return nil
+	}
+	portVars := make([]string, 0, len(ports))
+	for v := range ports {
+		portVars = append(portVars, v)
+	}
+	sort.Strings(portVars)
+
+	claims := loadPortClaims()
+
+	// A running instance listens on its own ports, e.g. before a restart
+	own := make(map[int]bool)
+	for _, port := range self.ListeningPortsWithSidecars() {
+		own[port] = true
+	}
+
+	for _, v := range portVars {
+		port := ports[v]
+		for _, claim := range claims[port] {
+			if claim.ref == self.Ref() {
+				continue
+			}
+			errMsg := fmt.Sprintf("Port %d (%s) is also claimed by %s (%s)", port, v, claim.ref, claim.portVar)
+			return errors.New(errMsg)
+		}
+		if own[port] {
+			continue
+		}
+		listening, pids, err := utils.PortListeners(port)
+		if err != nil || !listening {
+			continue
+		}
+		errMsg := fmt.Sprintf("Port %d (%s) is already in use", port, v)
+		if len(pids) > 0 {
+			errMsg = fmt.Sprintf("%s by pid %d", errMsg, pids[0])
+		}
+		return errors.New(errMsg)
+	}
+	return nil
+}
@@ -10,35 +10,53 @@ import (
 
 	// All packages modules need to be imported here:
 	"github.com/f4t/opsctl/packages/logstash"
+	"github.com/f4t/opsctl/packages/manifest"
 	"github.com/f4t/opsctl/packages/netprobe"
 	"github.com/f4t/opsctl/packages/node_exporter"
 )
 
 type ServiceInterface interface {
 	Self() instance.Instance
-	Start()
-	Stop()
+	// Start and Stop return errors of the kinds of instance.KindOf
+	Start() error
+	Stop() error
 	SetStartupCmd()
 	SetRuntimeCmd()
+	SetProbes()
+	SetSidecars()
 }
 
 // All package mappings need to be implemented here:
-func packageSelector(instance instance.Instance) (ServiceInterface, error) {
-	switch instance.Config.Type {
+// Compiled-in packages take priority over package manifests found in
+// $OPSCTL_HOME/packages/<type>/package.yaml
+func packageSelector(self instance.Instance) (ServiceInterface, error) {
+	switch self.Config.Type {
 	case "netprobe":
-		return &netprobe.Netprobe{Instance: instance}, nil
+		return &netprobe.Netprobe{Instance: self}, nil
 	case "logstash":
-		return &logstash.Logstash{Instance: instance}, nil
+		return &logstash.Logstash{Instance: self}, nil
 	case "node_exporter":
-		return &node_exporter.NodeExporter{Instance: instance}, nil
+		return &node_exporter.NodeExporter{Instance: self}, nil
 	default:
-		err := fmt.Sprintf("Unsupported instance type '%s'", instance.Config.Type)
-		return nil, errors.New(err)
+		if manifest.Exists(self.OpsctlEnv.Home, self.Config.Type) {
+			m, err := manifest.Load(self.OpsctlEnv.Home, self.Config.Type)
+			if err != nil {
+				return nil, instance.NewError(instance.KindBadConfig, err)
+			}
+			return &manifest.ManifestPackage{Instance: self, Manifest: m}, nil
+		}
+		err := fmt.Sprintf("Unsupported instance type '%s'", self.Config.Type)
+		return nil, instance.NewError(instance.KindNotFound, errors.New(err))
 	}
 }
 
 // MakeInstance returns a fully conigured / inspected instance struct
 func MakeInstance(Type string, Name string) (ServiceInterface, error) {
+	// Type and name end up in paths, check them before touching anything
+	err := instance.InstanceRef{Type: Type, Name: Name}.CheckPath()
+	if err != nil {
+		return nil, err
+	}
 	// Initialize generic instance data (name, type, exists, etc..)
 	instance := instance.MakeGenericInstance(Type, Name)
 	svc, err := loadSpecifics(instance)
@@ -64,9 +82,14 @@ func loadSpecifics(instance instance.Instance) (ServiceInterface, error) {
 	instance.Errors.Config = err
 	svc, _ = packageSelector(instance)
 
-	// Set Startup and Runtime command
+	// Set Startup and Runtime command, with EXTRA_ARGS from the rc file
 	svc.SetStartupCmd()
+	instance = svc.Self()
+	instance.Config.StartupArgs = append(instance.Config.StartupArgs, instance.Config.ExtraArgs...)
+	svc, _ = packageSelector(instance)
 	svc.SetRuntimeCmd()
+	svc.SetProbes()
+	svc.SetSidecars()
 
 	// Re-build instance with all specifics populated
 	instance = svc.Self() // Reflect to get instance details
@@ -81,7 +104,7 @@ func inspectRuntime(instance instance.Instance) ServiceInterface {
 	instance.State.PID = pid
 	svc, err := packageSelector(instance)
 	if err != nil {
-		log.Printf(err.Error())
+		log.Println(err)
 		os.Exit(1)
 	}
 	return svc
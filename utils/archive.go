@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CreateTarGz archives the content of srcDir into destPath. Entries are
+// stored under the base name of srcDir.
+func CreateTarGz(srcDir string, destPath string) error {
+	out, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gzipWriter := gzip.NewWriter(out)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	base := filepath.Dir(srcDir)
+	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name, err = filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		err = tarWriter.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tarWriter, f)
+		return err
+	})
+	if err == nil {
+		err = tarWriter.Close()
+	}
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		os.Remove(destPath)
+		return err
+	}
+	return out.Close()
+}
+
+// ExtractTarGz extracts the archive at srcPath into destDir, which must exist.
+// Entries escaping destDir are rejected, as well as symlinks pointing outside
+// of it and entries written through a symlink.
+func ExtractTarGz(srcPath string, destDir string) error {
+	destDir = filepath.Clean(destDir)
+	in, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gzipReader, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destDir, header.Name)
+		if !isInsideDir(destDir, target) {
+			errMsg := fmt.Sprintf("Archive entry %s points outside of %s", header.Name, destDir)
+			return errors.New(errMsg)
+		}
+		err = checkNoSymlinks(destDir, target)
+		if err != nil {
+			errMsg := fmt.Sprintf("Archive entry %s: %s", header.Name, err)
+			return errors.New(errMsg)
+		}
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode|0700)
+		case tar.TypeReg, tar.TypeRegA:
+			err = extractFile(tarReader, target, mode)
+		case tar.TypeSymlink:
+			linkTarget := filepath.Join(filepath.Dir(target), header.Linkname)
+			if filepath.IsAbs(header.Linkname) || !isInsideDir(destDir, linkTarget) {
+				errMsg := fmt.Sprintf("Archive entry %s links outside of %s", header.Name, destDir)
+				return errors.New(errMsg)
+			}
+			err = os.MkdirAll(filepath.Dir(target), 0755)
+			if err == nil {
+				err = os.Symlink(header.Linkname, target)
+			}
+		case tar.TypeLink:
+			linkTarget := filepath.Join(destDir, header.Linkname)
+			if !strings.HasPrefix(linkTarget, destDir+string(os.PathSeparator)) {
+				errMsg := fmt.Sprintf("Archive entry %s links outside of %s", header.Name, destDir)
+				return errors.New(errMsg)
+			}
+			err = os.Link(linkTarget, target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func isInsideDir(dir string, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// checkNoSymlinks refuses paths going through a symlink below dir, e.g. one
+// extracted from an earlier entry of the archive
+func checkNoSymlinks(dir string, path string) error {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." {
+		return err
+	}
+	current := dir
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			errMsg := fmt.Sprintf("%s is a symlink, not written through", current)
+			return errors.New(errMsg)
+		}
+	}
+	return nil
+}
+
+func extractFile(reader io.Reader, target string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, reader)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{}, false},
+		{"   ", []string{}, false},
+		{"-Xmx1g", []string{"-Xmx1g"}, false},
+		{"  --port  9000\t-v\n", []string{"--port", "9000", "-v"}, false},
+		{`--name "my service"`, []string{"--name", "my service"}, false},
+		{`--name 'my service'`, []string{"--name", "my service"}, false},
+		{`--opt="a b"c`, []string{"--opt=a bc"}, false},
+		{`'it'\''s'`, []string{"it's"}, false},
+		{`"say \"hi\""`, []string{`say "hi"`}, false},
+		{`'no \escape'`, []string{`no \escape`}, false},
+		{`a\ b c`, []string{"a b", "c"}, false},
+		{`"" ''`, []string{"", ""}, false},
+		{`$HOME *`, []string{"$HOME", "*"}, false},
+		{`"unterminated`, nil, true},
+		{`'unterminated`, nil, true},
+		{`trailing\`, nil, true},
+	}
+	for _, test := range tests {
+		got, err := SplitArgs(test.line)
+		if (err != nil) != test.wantErr {
+			t.Errorf("SplitArgs(%q) error = %v, wantErr %v", test.line, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestJoinArgsRoundTrip(t *testing.T) {
+	tests := [][]string{
+		{"/opt/bin/service", "--port", "9000"},
+		{"--name", "my service"},
+		{"it's", `say "hi"`, `back\slash`},
+		{"", "$HOME", "*"},
+	}
+	for _, args := range tests {
+		line := JoinArgs(args)
+		got, err := SplitArgs(line)
+		if err != nil {
+			t.Errorf("SplitArgs(JoinArgs(%q)) error = %v", args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, args) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", line, got, args)
+		}
+	}
+}
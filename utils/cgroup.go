@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/procfs"
+)
+
+// cgroup v2 period used for CPU_QUOTA, in microseconds
+const cpuPeriod = 100000
+
+// PrepareCgroup creates the cgroup name under base, a delegated cgroup v2
+// directory opsctl may write to, and sets its memory and CPU limits.
+// Returns the cgroup path.
+func PrepareCgroup(base string, name string, limits ProcessLimits) (string, error) {
+	if _, err := os.Stat(filepath.Join(base, "cgroup.controllers")); err != nil {
+		errMsg := fmt.Sprintf("%s is not a cgroup v2 directory", base)
+		return "", errors.New(errMsg)
+	}
+	// Controllers must be enabled by the parent for its children
+	controllers := make([]string, 0)
+	if limits.MemoryMax > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if limits.CPUQuota > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	err := ioutil.WriteFile(filepath.Join(base, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0644)
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed enabling cgroup controllers in %s: %s", base, err)
+		return "", errors.New(errMsg)
+	}
+
+	cgroupPath := filepath.Join(base, name)
+	err = os.MkdirAll(cgroupPath, 0755)
+	if err != nil {
+		return "", err
+	}
+	// Unset limits are reset, in case they were removed from the rc file
+	memoryMax := "max"
+	if limits.MemoryMax > 0 {
+		memoryMax = strconv.FormatInt(limits.MemoryMax, 10)
+	}
+	cpuMax := fmt.Sprintf("max %d", cpuPeriod)
+	if limits.CPUQuota > 0 {
+		cpuMax = fmt.Sprintf("%d %d", limits.CPUQuota*cpuPeriod/100, cpuPeriod)
+	}
+	for file, value := range map[string]string{"memory.max": memoryMax, "cpu.max": cpuMax} {
+		err = ioutil.WriteFile(filepath.Join(cgroupPath, file), []byte(value), 0644)
+		if err != nil && !os.IsNotExist(err) {
+			errMsg := fmt.Sprintf("Failed setting %s of %s: %s", file, cgroupPath, err)
+			return "", errors.New(errMsg)
+		}
+	}
+	return cgroupPath, nil
+}
+
+// JoinCgroup moves pid into cgroupPath
+func JoinCgroup(cgroupPath string, pid int) error {
+	err := ioutil.WriteFile(filepath.Join(cgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed joining cgroup %s: %s", cgroupPath, err)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// RemoveCgroup removes cgroupPath once it has no process left
+func RemoveCgroup(cgroupPath string) error {
+	if cgroupPath == "" {
+		return nil
+	}
+	err := os.Remove(cgroupPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// cgroupRoot returns where the cgroup v2 hierarchy is mounted, if anywhere
+func cgroupRoot() string {
+	for _, root := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+		if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+			return root
+		}
+	}
+	return ""
+}
+
+// effectiveCgroupLimits reads the memory and CPU limits of the cgroup v2 of proc
+func effectiveCgroupLimits(proc procfs.Proc) map[string]string {
+	effective := make(map[string]string)
+	root := cgroupRoot()
+	if root == "" {
+		return effective
+	}
+	cgroups, err := proc.Cgroups()
+	if err != nil {
+		return effective
+	}
+	for _, cgroup := range cgroups {
+		if cgroup.HierarchyID != 0 {
+			continue
+		}
+		cgroupPath := filepath.Join(root, cgroup.Path)
+		if content, err := ioutil.ReadFile(filepath.Join(cgroupPath, "memory.max")); err == nil {
+			effective[MemoryMaxVar] = strings.TrimSpace(string(content))
+		}
+		if content, err := ioutil.ReadFile(filepath.Join(cgroupPath, "cpu.max")); err == nil {
+			fields := strings.Fields(string(content))
+			if len(fields) == 2 && fields[0] != "max" {
+				quota, _ := strconv.Atoi(fields[0])
+				period, _ := strconv.Atoi(fields[1])
+				if period > 0 {
+					effective[CPUQuotaVar] = fmt.Sprintf("%d%%", quota*100/period)
+				}
+			} else if len(fields) > 0 {
+				effective[CPUQuotaVar] = fields[0]
+			}
+		}
+	}
+	return effective
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns "1\n2\n...\nn\n", with the replaced lines changed
+func numberedLines(n int, replaced map[int]string) string {
+	var out strings.Builder
+	for i := 1; i <= n; i++ {
+		line, found := replaced[i]
+		if !found {
+			line = strconv.Itoa(i)
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		want    string
+	}{
+		{
+			name:    "equal",
+			oldText: "a\nb\n",
+			newText: "a\nb\n",
+			want:    "",
+		},
+		{
+			name:    "insert at start",
+			oldText: "b\nc\n",
+			newText: "a\nb\nc\n",
+			want:    "--- old\n+++ new\n@@ -1,2 +1,3 @@\n+a\n b\n c\n",
+		},
+		{
+			name:    "delete at end",
+			oldText: "a\nb\nc\n",
+			newText: "a\nb\n",
+			want:    "--- old\n+++ new\n@@ -1,3 +1,2 @@\n a\n b\n-c\n",
+		},
+		{
+			name:    "empty old text",
+			oldText: "",
+			newText: "a\nb\n",
+			want:    "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:    "empty new text",
+			oldText: "a\n",
+			newText: "",
+			want:    "--- old\n+++ new\n@@ -1,1 +0,0 @@\n-a\n",
+		},
+		{
+			name:    "context limited to 3 lines",
+			oldText: numberedLines(10, nil),
+			newText: numberedLines(10, map[int]string{5: "five"}),
+			want:    "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name:    "hunks merged within 2*context lines",
+			oldText: numberedLines(20, nil),
+			newText: numberedLines(20, map[int]string{5: "five", 12: "twelve"}),
+			want: "--- old\n+++ new\n@@ -2,14 +2,14 @@\n 2\n 3\n 4\n-5\n+five\n" +
+				" 6\n 7\n 8\n 9\n 10\n 11\n-12\n+twelve\n 13\n 14\n 15\n",
+		},
+		{
+			name:    "hunks split beyond 2*context lines",
+			oldText: numberedLines(20, nil),
+			newText: numberedLines(20, map[int]string{5: "five", 13: "thirteen"}),
+			want: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n" +
+				"@@ -10,7 +10,7 @@\n 10\n 11\n 12\n-13\n+thirteen\n 14\n 15\n 16\n",
+		},
+		{
+			name:    "missing final newline",
+			oldText: "a\nb",
+			newText: "a\nc",
+			want:    "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n",
+		},
+	}
+	for _, test := range tests {
+		got := UnifiedDiff("old", "new", test.oldText, test.newText)
+		if got != test.want {
+			t.Errorf("%s: UnifiedDiff() =\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
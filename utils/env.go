@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/joho/godotenv"
 	"github.com/mitchellh/go-homedir"
@@ -12,14 +13,31 @@ import (
 const (
 	dotOpsctlFilename = ".opsctl"
 	servicesHomeVar   = "OPSCTL_HOME"
+	cgroupVar         = "OPSCTL_CGROUP"
 	packageVersionVar = "SERVICE_PACKAGE_VERSION"
 )
 
 type OpsctlEnv struct {
 	Home string
+	// Delegated cgroup v2 directory for instances cgroups, optional
+	Cgroup string
 }
 
+var (
+	opsctlEnvOnce sync.Once
+	opsctlEnv     OpsctlEnv
+)
+
+// LoadOpsctlEnv loads ~/.opsctl into the process environment once, so that
+// instances made concurrently never touch it
 func LoadOpsctlEnv() OpsctlEnv {
+	opsctlEnvOnce.Do(func() {
+		opsctlEnv = loadOpsctlEnv()
+	})
+	return opsctlEnv
+}
+
+func loadOpsctlEnv() OpsctlEnv {
 
 	// Find system home dir path
 	home, err := homedir.Dir()
@@ -55,6 +73,7 @@ func LoadOpsctlEnv() OpsctlEnv {
 	}
 
 	return OpsctlEnv{
-		Home: servicesHome,
+		Home:   servicesHome,
+		Cgroup: os.Getenv(cgroupVar),
 	}
 }
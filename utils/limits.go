@@ -0,0 +1,303 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/prometheus/procfs"
+)
+
+// Rc variables constraining the resources of an instance process
+const (
+	LimitNoFileVar  = "LIMIT_NOFILE"
+	LimitCoreVar    = "LIMIT_CORE"
+	NiceVar         = "NICE"
+	IOniceClassVar  = "IONICE_CLASS"
+	IOniceLevelVar  = "IONICE_LEVEL"
+	CPUAffinityVar  = "CPU_AFFINITY"
+	MemoryMaxVar    = "MEMORY_MAX"
+	CPUQuotaVar     = "CPU_QUOTA"
+	rlimitInfinity  = ^uint64(0)
+	ioprioWhoProc   = 1
+	ioprioClassBits = 13
+)
+
+var LimitRcVars = []string{
+	LimitNoFileVar,
+	LimitCoreVar,
+	NiceVar,
+	IOniceClassVar,
+	IOniceLevelVar,
+	CPUAffinityVar,
+	MemoryMaxVar,
+	CPUQuotaVar,
+}
+
+var ioniceClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// ProcessLimits are applied to a process before it execs the instance
+// command, except MemoryMax and CPUQuota which need a cgroup.
+// Nil or zero values are left to the system.
+type ProcessLimits struct {
+	NoFile      *uint64
+	Core        *uint64
+	Nice        *int
+	IOClass     int
+	IOLevel     int
+	CPUAffinity []int
+	MemoryMax   int64 // bytes
+	CPUQuota    int   // percent of one CPU, e.g. 150
+	values      map[string]string
+}
+
+// ParseProcessLimits reads limits from rc values, e.g. LIMIT_NOFILE=65536,
+// NICE=10, IONICE_CLASS=idle, CPU_AFFINITY=0-3,6, MEMORY_MAX=2G, CPU_QUOTA=150%
+func ParseProcessLimits(values map[string]string) (ProcessLimits, error) {
+	limits := ProcessLimits{values: make(map[string]string)}
+	invalid := func(name string, value string, expected string) error {
+		errMsg := fmt.Sprintf("Invalid %s '%s', expected %s", name, value, expected)
+		return errors.New(errMsg)
+	}
+	for _, name := range LimitRcVars {
+		value := strings.TrimSpace(values[name])
+		if value == "" {
+			continue
+		}
+		limits.values[name] = value
+		switch name {
+		case LimitNoFileVar, LimitCoreVar:
+			limit := rlimitInfinity
+			if value != "unlimited" {
+				parsed, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return limits, invalid(name, value, "a number or unlimited")
+				}
+				limit = parsed
+			}
+			if name == LimitNoFileVar {
+				limits.NoFile = &limit
+			} else {
+				limits.Core = &limit
+			}
+		case NiceVar:
+			nice, err := strconv.Atoi(value)
+			if err != nil || nice < -20 || nice > 19 {
+				return limits, invalid(name, value, "a number from -20 to 19")
+			}
+			limits.Nice = &nice
+		case IOniceClassVar:
+			class, found := ioniceClasses[value]
+			if !found {
+				return limits, invalid(name, value, "realtime, best-effort or idle")
+			}
+			limits.IOClass = class
+		case IOniceLevelVar:
+			level, err := strconv.Atoi(value)
+			if err != nil || level < 0 || level > 7 {
+				return limits, invalid(name, value, "a number from 0 to 7")
+			}
+			limits.IOLevel = level
+		case CPUAffinityVar:
+			cpus, err := parseCPUList(value)
+			if err != nil {
+				return limits, invalid(name, value, "a CPU list such as 0-3,6")
+			}
+			limits.CPUAffinity = cpus
+		case MemoryMaxVar:
+			size, err := ParseByteSize(value)
+			if err != nil || size == 0 {
+				return limits, invalid(name, value, "a size such as 2G")
+			}
+			limits.MemoryMax = size
+		case CPUQuotaVar:
+			quota, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil || quota <= 0 {
+				return limits, invalid(name, value, "a percentage of one CPU such as 150%")
+			}
+			limits.CPUQuota = quota
+		}
+	}
+	if limits.values[IOniceLevelVar] != "" && limits.IOClass == 0 {
+		limits.IOClass = ioniceClasses["best-effort"]
+	}
+	return limits, nil
+}
+
+// Values returns the rc values limits were parsed from
+func (limits ProcessLimits) Values() map[string]string {
+	return limits.values
+}
+
+// NeedsCgroup tells whether limits can only be enforced through a cgroup
+func (limits ProcessLimits) NeedsCgroup() bool {
+	return limits.MemoryMax > 0 || limits.CPUQuota > 0
+}
+
+// LauncherArgs encodes limits as arguments of `opsctl launch`
+func (limits ProcessLimits) LauncherArgs() []string {
+	names := make([]string, 0, len(limits.values))
+	for name := range limits.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := make([]string, 0, len(names))
+	for _, name := range names {
+		args = append(args, fmt.Sprintf("--limit=%s=%s", name, limits.values[name]))
+	}
+	return args
+}
+
+// parseCPUList parses lists such as 0-3,6
+func parseCPUList(value string) ([]int, error) {
+	cpus := make([]int, 0)
+	for _, item := range strings.Split(value, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(item), "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, errors.New("invalid CPU list")
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, errors.New("invalid CPU list")
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// Launch applies limits to the current process then execs cmdArgs. SIGHUP is
+// ignored, as with nohup. With waitCgroup, first waits for fd 3 to be closed
+// by the parent once it has moved the process into its cgroup.
+// Only returns on error.
+func Launch(limits ProcessLimits, waitCgroup bool, cmdArgs []string) error {
+	if waitCgroup {
+		ready := os.NewFile(3, "cgroup-ready")
+		ioutil.ReadAll(ready)
+		ready.Close()
+	}
+
+	// nice, ionice and affinity apply to the calling thread, which must be
+	// the one calling exec
+	runtime.LockOSThread()
+	signal.Ignore(syscall.SIGHUP)
+
+	if limits.NoFile != nil {
+		err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{Cur: *limits.NoFile, Max: *limits.NoFile})
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed setting %s: %s", LimitNoFileVar, err)
+			return errors.New(errMsg)
+		}
+	}
+	if limits.Core != nil {
+		err := syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{Cur: *limits.Core, Max: *limits.Core})
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed setting %s: %s", LimitCoreVar, err)
+			return errors.New(errMsg)
+		}
+	}
+	if limits.Nice != nil {
+		err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, *limits.Nice)
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed setting %s: %s", NiceVar, err)
+			return errors.New(errMsg)
+		}
+	}
+	if limits.IOClass > 0 {
+		ioprio := limits.IOClass<<ioprioClassBits | limits.IOLevel
+		_, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProc, 0, uintptr(ioprio))
+		if errno != 0 {
+			errMsg := fmt.Sprintf("Failed setting %s: %s", IOniceClassVar, errno)
+			return errors.New(errMsg)
+		}
+	}
+	if len(limits.CPUAffinity) > 0 {
+		var mask [16]uint64
+		for _, cpu := range limits.CPUAffinity {
+			if cpu >= len(mask)*64 {
+				errMsg := fmt.Sprintf("Failed setting %s: CPU %d out of range", CPUAffinityVar, cpu)
+				return errors.New(errMsg)
+			}
+			mask[cpu/64] |= 1 << uint(cpu%64)
+		}
+		_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+		if errno != 0 {
+			errMsg := fmt.Sprintf("Failed setting %s: %s", CPUAffinityVar, errno)
+			return errors.New(errMsg)
+		}
+	}
+	executable, err := exec.LookPath(cmdArgs[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(executable, cmdArgs, os.Environ())
+}
+
+// EffectiveLimits reads the limits a running process is actually subject to,
+// keyed by rc variable name
+func EffectiveLimits(pid int) map[string]string {
+	effective := make(map[string]string)
+	proc, err := procfs.NewProc(pid)
+	if err != nil {
+		return effective
+	}
+	formatRlimit := func(value uint64) string {
+		if value == rlimitInfinity {
+			return "unlimited"
+		}
+		return strconv.FormatUint(value, 10)
+	}
+	if rlimits, err := proc.Limits(); err == nil {
+		effective[LimitNoFileVar] = formatRlimit(rlimits.OpenFiles)
+		effective[LimitCoreVar] = formatRlimit(rlimits.CoreFileSize)
+	}
+	if stat, err := proc.Stat(); err == nil {
+		effective[NiceVar] = strconv.Itoa(stat.Nice)
+	}
+
+	ioprio, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_GET, ioprioWhoProc, uintptr(pid), 0)
+	if errno == 0 {
+		class := int(ioprio) >> ioprioClassBits
+		for name, value := range ioniceClasses {
+			if value == class {
+				effective[IOniceClassVar] = name
+				effective[IOniceLevelVar] = strconv.Itoa(int(ioprio) & (1<<ioprioClassBits - 1))
+			}
+		}
+		if class == 0 {
+			// Follows the nice value
+			effective[IOniceClassVar] = "none"
+		}
+	}
+
+	if status, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid)); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if strings.HasPrefix(line, "Cpus_allowed_list:") {
+				effective[CPUAffinityVar] = strings.TrimSpace(strings.TrimPrefix(line, "Cpus_allowed_list:"))
+			}
+		}
+	}
+
+	for name, value := range effectiveCgroupLimits(proc) {
+		effective[name] = value
+	}
+	return effective
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Polling interval while waiting for a lock
+const lockPollingTime = 100 * time.Millisecond
+
+// LockHolder is a process holding a lock
+type LockHolder struct {
+	PID     int
+	Command string
+}
+
+func (holder LockHolder) String() string {
+	return fmt.Sprintf("pid=%d (%s)", holder.PID, holder.Command)
+}
+
+// LockHeldError reports a lock held by other processes
+type LockHeldError struct {
+	Path    string
+	Holders []LockHolder
+}
+
+func (err LockHeldError) Error() string {
+	if len(err.Holders) == 0 {
+		return fmt.Sprintf("Lock %s is held by another process", err.Path)
+	}
+	holders := make([]string, len(err.Holders))
+	for i, holder := range err.Holders {
+		holders[i] = holder.String()
+	}
+	return fmt.Sprintf("Lock %s is held by %s", err.Path, strings.Join(holders, ", "))
+}
+
+// FileLock is an advisory flock on a file
+type FileLock struct {
+	path      string
+	file      *os.File
+	exclusive bool
+	// Times the lock was taken by this process
+	count int
+}
+
+// Locks taken by this process, by path. A lock taken again by this process,
+// e.g. when 'restart all' stops an instance, is only counted: flock would
+// otherwise block on its own lock.
+var heldLocks = struct {
+	sync.Mutex
+	locks map[string]*FileLock
+}{locks: make(map[string]*FileLock)}
+
+// AcquireLock takes the lock on path, shared or exclusive, waiting for up to
+// timeout when it is held by another process. The pid and command line of an
+// exclusive holder are recorded in the lock file, those of each shared holder
+// in <path>.d/<pid>.
+func AcquireLock(path string, exclusive bool, timeout time.Duration) (*FileLock, error) {
+	heldLocks.Lock()
+	if lock, found := heldLocks.locks[path]; found {
+		lock.count++
+		heldLocks.Unlock()
+		return lock, nil
+	}
+	heldLocks.Unlock()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	waiting := false
+	for start := time.Now(); ; time.Sleep(lockPollingTime) {
+		err = syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, err
+		}
+		if time.Since(start) >= timeout {
+			file.Close()
+			return nil, lockHolder(path)
+		}
+		if !waiting {
+			log.Printf("Waiting for up to %s: %s", timeout, lockHolder(path))
+			waiting = true
+		}
+	}
+
+	holder := fmt.Sprintf("%d\n%s\n", os.Getpid(), JoinArgs(os.Args))
+	if exclusive {
+		file.Truncate(0)
+		file.WriteAt([]byte(holder), 0)
+	} else {
+		err = os.MkdirAll(sharedHoldersDir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(sharedHolderPath(path), []byte(holder), 0644)
+		}
+		if err != nil {
+			log.Printf("Unable to record holder of lock %s: %s", path, err)
+		}
+	}
+	lock := &FileLock{path: path, file: file, exclusive: exclusive, count: 1}
+	heldLocks.Lock()
+	heldLocks.locks[path] = lock
+	heldLocks.Unlock()
+	return lock, nil
+}
+
+// Release releases the lock once it has been released as many times as it
+// was taken
+func (lock *FileLock) Release() error {
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+	lock.count--
+	if lock.count > 0 {
+		return nil
+	}
+	delete(heldLocks.locks, lock.path)
+	if lock.exclusive {
+		lock.file.Truncate(0)
+	} else {
+		os.Remove(sharedHolderPath(lock.path))
+	}
+	// Closing the file releases the flock
+	return lock.file.Close()
+}
+
+func sharedHoldersDir(path string) string {
+	return path + ".d"
+}
+
+func sharedHolderPath(path string) string {
+	return filepath.Join(sharedHoldersDir(path), strconv.Itoa(os.Getpid()))
+}
+
+// lockHolder reads the holders recorded for a lock: the exclusive one, or
+// the shared ones. Records left by processes which are gone are ignored.
+func lockHolder(path string) LockHeldError {
+	held := LockHeldError{Path: path, Holders: make([]LockHolder, 0)}
+	if holder, ok := readLockHolder(path); ok {
+		held.Holders = append(held.Holders, holder)
+		return held
+	}
+	files, err := ioutil.ReadDir(sharedHoldersDir(path))
+	if err != nil {
+		return held
+	}
+	for _, f := range files {
+		holder, ok := readLockHolder(filepath.Join(sharedHoldersDir(path), f.Name()))
+		if ok {
+			held.Holders = append(held.Holders, holder)
+		}
+	}
+	return held
+}
+
+// readLockHolder reads a "<pid>\n<command>\n" record of a running process
+func readLockHolder(path string) (LockHolder, bool) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return LockHolder{}, false
+	}
+	lines := strings.SplitN(string(content), "\n", 3)
+	if len(lines) < 2 {
+		return LockHolder{}, false
+	}
+	pid, err := strconv.Atoi(lines[0])
+	if err != nil || !processExists(pid) {
+		return LockHolder{}, false
+	}
+	return LockHolder{PID: pid, Command: lines[1]}, true
+}
+
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
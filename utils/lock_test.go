@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// lockedByOther tells whether another open file, as another process would,
+// can take the lock on path with how
+func lockedByOther(t *testing.T, path string, how int) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	return syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB) != nil
+}
+
+func TestAcquireLockReentrant(t *testing.T) {
+	tests := []struct {
+		name      string
+		exclusive bool
+		// How another process tries to take the lock while it is held
+		otherHow int
+	}{
+		{"exclusive", true, syscall.LOCK_SH},
+		{"shared", false, syscall.LOCK_EX},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "test.lock")
+		first, err := AcquireLock(path, test.exclusive, 0)
+		if err != nil {
+			t.Fatalf("%s: AcquireLock() error = %v", test.name, err)
+		}
+		// Taken again by this process, without waiting on its own flock
+		second, err := AcquireLock(path, test.exclusive, 0)
+		if err != nil {
+			t.Fatalf("%s: AcquireLock() again error = %v", test.name, err)
+		}
+		if first != second {
+			t.Errorf("%s: AcquireLock() again returned another lock", test.name)
+		}
+
+		second.Release()
+		if !lockedByOther(t, path, test.otherHow) {
+			t.Errorf("%s: lock released while still taken once", test.name)
+		}
+		first.Release()
+		if lockedByOther(t, path, test.otherHow) {
+			t.Errorf("%s: lock still held after being released as many times as taken", test.name)
+		}
+
+		// Taken anew once fully released
+		third, err := AcquireLock(path, test.exclusive, 0)
+		if err != nil {
+			t.Fatalf("%s: AcquireLock() after release error = %v", test.name, err)
+		}
+		third.Release()
+	}
+}
+
+func TestAcquireLockHeldByOther(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	// Another process holds the lock exclusively, and recorded itself
+	err := ioutil.WriteFile(path, []byte("1\nopsctl stop all\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	err = syscall.Flock(int(other.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := 3 * lockPollingTime
+	start := time.Now()
+	_, err = AcquireLock(path, false, timeout)
+	if time.Since(start) < timeout {
+		t.Errorf("AcquireLock() returned after %s, before timeout %s", time.Since(start), timeout)
+	}
+	var held LockHeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("AcquireLock() error = %v, want LockHeldError", err)
+	}
+	if held.Path != path || len(held.Holders) != 1 || held.Holders[0].PID != 1 || held.Holders[0].Command != "opsctl stop all" {
+		t.Errorf("AcquireLock() error = %+v, want held by pid=1 (opsctl stop all)", held)
+	}
+
+	// Released by the other process
+	other.Close()
+	lock, err := AcquireLock(path, false, timeout)
+	if err != nil {
+		t.Fatalf("AcquireLock() after release error = %v", err)
+	}
+	lock.Release()
+}
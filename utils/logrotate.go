@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const lastRotationMarker = ".last_rotation"
+
+// RotatePolicy tells when a log file is rotated and how many archives are kept.
+// Zero values disable the corresponding check.
+type RotatePolicy struct {
+	MaxSize      int64
+	MaxAge       time.Duration
+	Keep         int
+	MaxTotalSize int64
+}
+
+// ParseByteSize parses sizes such as 512, 100K, 100M, 2G
+func ParseByteSize(input string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(input))
+	value = strings.TrimSuffix(value, "B")
+	multiplier := int64(1)
+	units := map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	if len(value) > 0 {
+		if unit, found := units[value[len(value)-1:]]; found {
+			multiplier = unit
+			value = value[:len(value)-1]
+		}
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		errMsg := fmt.Sprintf("Invalid size '%s'", input)
+		return 0, errors.New(errMsg)
+	}
+	return size * multiplier, nil
+}
+
+// RotateLog compresses logPath into archiveDir and truncates it in place,
+// when it is due according to policy or force is set. Processes writing the
+// log in append mode carry on writing to the truncated file, without restart.
+// Returns the archive path, empty when nothing was rotated.
+func RotateLog(logPath string, archiveDir string, policy RotatePolicy, force bool) (string, error) {
+	stat, err := os.Stat(logPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if stat.Size() == 0 {
+		return "", nil
+	}
+	err = os.MkdirAll(archiveDir, 0755)
+	if err != nil {
+		return "", err
+	}
+	if !force && !rotationDue(stat, archiveDir, policy) {
+		return "", nil
+	}
+
+	archivePath := filepath.Join(
+		archiveDir,
+		fmt.Sprintf("%s.%s.gz", filepath.Base(logPath), time.Now().Format("20060102-150405")),
+	)
+	for i := 1; fileExists(archivePath); i++ {
+		archivePath = filepath.Join(
+			archiveDir,
+			fmt.Sprintf("%s.%s-%d.gz", filepath.Base(logPath), time.Now().Format("20060102-150405"), i),
+		)
+	}
+
+	err = gzipFile(logPath, archivePath)
+	if err != nil {
+		os.Remove(archivePath)
+		return "", err
+	}
+	// Lines written between the copy and the truncation are lost
+	err = os.Truncate(logPath, 0)
+	if err != nil {
+		return archivePath, err
+	}
+	return archivePath, touchMarker(archiveDir)
+}
+
+func rotationDue(stat os.FileInfo, archiveDir string, policy RotatePolicy) bool {
+	if policy.MaxSize > 0 && stat.Size() >= policy.MaxSize {
+		return true
+	}
+	if policy.MaxAge > 0 {
+		marker, err := os.Stat(filepath.Join(archiveDir, lastRotationMarker))
+		if err != nil {
+			// Start counting from now
+			touchMarker(archiveDir)
+			return false
+		}
+		return time.Since(marker.ModTime()) >= policy.MaxAge
+	}
+	return false
+}
+
+func touchMarker(archiveDir string) error {
+	return ioutil.WriteFile(filepath.Join(archiveDir, lastRotationMarker), []byte(time.Now().Format(time.RFC3339)+"\n"), 0644)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func gzipFile(srcPath string, destPath string) error {
+	in, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gzipWriter := gzip.NewWriter(out)
+	_, err = io.Copy(gzipWriter, in)
+	if err != nil {
+		return err
+	}
+	err = gzipWriter.Close()
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// ArchivedLogs returns archives of archiveDir, oldest first
+func ArchivedLogs(archiveDir string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(archiveDir)
+	if err != nil {
+		return nil, err
+	}
+	archives := make([]os.FileInfo, 0)
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".gz") {
+			archives = append(archives, f)
+		}
+	}
+	sort.SliceStable(archives, func(i, j int) bool {
+		return archives[i].ModTime().Before(archives[j].ModTime())
+	})
+	return archives, nil
+}
+
+// EnforceRetention deletes the oldest archives of archiveDir beyond the
+// policy count and total size. Returns the deleted paths.
+func EnforceRetention(archiveDir string, policy RotatePolicy) ([]string, error) {
+	archives, err := ArchivedLogs(archiveDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var totalSize int64
+	for _, f := range archives {
+		totalSize += f.Size()
+	}
+
+	removed := make([]string, 0)
+	for len(archives) > 0 {
+		tooMany := policy.Keep > 0 && len(archives) > policy.Keep
+		tooLarge := policy.MaxTotalSize > 0 && totalSize > policy.MaxTotalSize
+		if !tooMany && !tooLarge {
+			break
+		}
+		path := filepath.Join(archiveDir, archives[0].Name())
+		err = os.Remove(path)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+		totalSize -= archives[0].Size()
+		archives = archives[1:]
+	}
+	return removed, nil
+}
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LogLine is a line read from a log file. Time is zero when unknown.
+type LogLine struct {
+	Source string
+	Text   string
+	Time   time.Time
+}
+
+var (
+	// e.g. "2021-05-03 10:00:00", "[2021-05-03T10:00:00,123]", "2021/05/03 10:00:00"
+	logTimePrefix = regexp.MustCompile(`^\[?(\d{4})[-/](\d{2})[-/](\d{2})[T ](\d{2}):(\d{2}):(\d{2})`)
+	// e.g. "level=info ts=2021-05-03T10:00:00.000Z caller=..."
+	logTimeField = regexp.MustCompile(`\bts=(\S+)`)
+)
+
+// ParseLogTime extracts the timestamp of a log line, in the formats used by
+// the supported packages.
+func ParseLogTime(line string) (time.Time, bool) {
+	if match := logTimeField.FindStringSubmatch(line); match != nil {
+		t, err := time.Parse(time.RFC3339Nano, match[1])
+		if err == nil {
+			return t, true
+		}
+	}
+	if match := logTimePrefix.FindStringSubmatch(line); match != nil {
+		value := strings.Join(match[1:4], "-") + " " + strings.Join(match[4:7], ":")
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ReadLogLines reads path, gzipped if its name ends in .gz, and returns its
+// last n lines (all of them if n <= 0). Lines without a timestamp get the one
+// of the previous line.
+func ReadLogLines(source string, path string, n int) ([]LogLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var reader io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	lines := make([]LogLine, 0)
+	var lastTime time.Time
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := LogLine{Source: source, Text: scanner.Text()}
+		if t, found := ParseLogTime(line.Text); found {
+			lastTime = t
+		}
+		line.Time = lastTime
+		lines = append(lines, line)
+		// Only keep what is needed
+		if n > 0 && len(lines) > 2*n {
+			lines = append(lines[:0], lines[len(lines)-n:]...)
+		}
+	}
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, scanner.Err()
+}
+
+// MergeLogLines interleaves lines of several sources by timestamp, keeping
+// the order of each source.
+func MergeLogLines(sources [][]LogLine) []LogLine {
+	merged := make([]LogLine, 0)
+	for _, lines := range sources {
+		merged = append(merged, lines...)
+	}
+	if len(sources) > 1 {
+		sort.SliceStable(merged, func(i, j int) bool {
+			return merged[i].Time.Before(merged[j].Time)
+		})
+	}
+	return merged
+}
+
+// FollowLog sends lines appended to path from offset until stop is closed.
+// Follows the file across rotation: truncation (copytruncate) restarts from
+// its beginning, a new file at path is reopened.
+func FollowLog(source string, path string, offset int64, lines chan<- LogLine, stop <-chan struct{}) {
+	var f *os.File
+	var reader *bufio.Reader
+	partial := ""
+	open := func() bool {
+		var err error
+		f, err = os.Open(path)
+		if err != nil {
+			return false
+		}
+		stat, err := f.Stat()
+		if err != nil || stat.Size() < offset {
+			offset = 0
+		}
+		f.Seek(offset, io.SeekStart)
+		reader = bufio.NewReader(f)
+		return true
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if f == nil && !open() {
+			offset = 0
+		}
+		for f != nil {
+			chunk, err := reader.ReadString('\n')
+			offset += int64(len(chunk))
+			if err != nil {
+				partial += chunk
+				break
+			}
+			text := strings.TrimRight(partial+chunk, "\r\n")
+			partial = ""
+			line := LogLine{Source: source, Text: text}
+			line.Time, _ = ParseLogTime(text)
+			lines <- line
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if f == nil {
+			continue
+		}
+		// Detect rotation
+		current, err := os.Stat(path)
+		opened, errOpened := f.Stat()
+		if err != nil || errOpened != nil {
+			continue
+		}
+		if !os.SameFile(current, opened) {
+			f.Close()
+			f = nil
+			offset = 0
+			partial = ""
+		} else if current.Size() < offset {
+			offset = 0
+			partial = ""
+			f.Seek(0, io.SeekStart)
+			reader.Reset(f)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PidFile identifies a process by its pid and its start time (in clock ticks
+// since boot), so that a pid reused by another process is not mistaken for it.
+type PidFile struct {
+	Pid       int
+	StartTime uint64
+}
+
+func WritePidFile(path string, pid int) error {
+	stat, err := GetProcStats(pid)
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to read start time of pid=%d: %s", pid, err)
+		return errors.New(errMsg)
+	}
+	content := fmt.Sprintf("%d %d\n", pid, stat.Starttime)
+	// Write to a temporary file first so readers never see a partial pid file
+	tmpPath := path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, []byte(content), 0644)
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed writing pid file %s", tmpPath)
+		return errors.New(errMsg)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func ReadPidFile(path string) (PidFile, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return PidFile{}, err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) != 2 {
+		errMsg := fmt.Sprintf("Malformed pid file %s", path)
+		return PidFile{}, errors.New(errMsg)
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		errMsg := fmt.Sprintf("Malformed pid in pid file %s", path)
+		return PidFile{}, errors.New(errMsg)
+	}
+	startTime, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		errMsg := fmt.Sprintf("Malformed start time in pid file %s", path)
+		return PidFile{}, errors.New(errMsg)
+	}
+	return PidFile{Pid: pid, StartTime: startTime}, nil
+}
+
+func RemovePidFile(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// IsAlive returns true if the recorded pid is running and is still the same
+// process, i.e. it has not exited and been reused since the pid file was written.
+func (pidFile PidFile) IsAlive() bool {
+	stat, err := GetProcStats(pidFile.Pid)
+	if err != nil {
+		return false
+	}
+	// Zombies are dead processes waiting to be reaped
+	if stat.State == "Z" {
+		return false
+	}
+	return stat.Starttime == pidFile.StartTime
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/procfs"
+)
+
+// State of listening sockets in /proc/net/tcp{,6}
+const tcpListen = 0x0A
+
+// ParsePort parses a TCP port number
+func ParsePort(value string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || port < 1 || port > 65535 {
+		errMsg := fmt.Sprintf("Invalid port '%s'", value)
+		return 0, errors.New(errMsg)
+	}
+	return port, nil
+}
+
+// listeningSockets maps inodes of listening TCP sockets, IPv4 and IPv6, to their port
+func listeningSockets() (map[uint64]int, error) {
+	fs, err := procfs.NewDefaultFS()
+	if err != nil {
+		return nil, err
+	}
+	sockets := make(map[uint64]int)
+	for _, read := range []func() (procfs.NetTCP, error){fs.NetTCP, fs.NetTCP6} {
+		lines, err := read()
+		if err != nil {
+			// e.g. IPv6 disabled
+			continue
+		}
+		for _, line := range lines {
+			if line.St == tcpListen {
+				sockets[line.Inode] = int(line.LocalPort)
+			}
+		}
+	}
+	return sockets, nil
+}
+
+// socketInodes returns inodes of the sockets opened by pid
+func socketInodes(proc procfs.Proc) ([]uint64, error) {
+	targets, err := proc.FileDescriptorTargets()
+	if err != nil {
+		return nil, err
+	}
+	inodes := make([]uint64, 0)
+	for _, target := range targets {
+		if !strings.HasPrefix(target, "socket:[") {
+			continue
+		}
+		inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]"), 10, 64)
+		if err == nil {
+			inodes = append(inodes, inode)
+		}
+	}
+	return inodes, nil
+}
+
+// ListeningPorts returns the TCP ports pid listens on, sorted
+func ListeningPorts(pid int) ([]int, error) {
+	proc, err := procfs.NewProc(pid)
+	if err != nil {
+		return nil, err
+	}
+	inodes, err := socketInodes(proc)
+	if err != nil {
+		return nil, err
+	}
+	sockets, err := listeningSockets()
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[int]bool)
+	ports := make([]int, 0)
+	for _, inode := range inodes {
+		port, listening := sockets[inode]
+		if listening && !found[port] {
+			found[port] = true
+			ports = append(ports, port)
+		}
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// PortListeners tells whether a TCP port is listened on, and by which pids.
+// Pids are only found for processes whose file descriptors can be read.
+func PortListeners(port int) (bool, []int, error) {
+	sockets, err := listeningSockets()
+	if err != nil {
+		return false, nil, err
+	}
+	portInodes := make(map[uint64]bool)
+	for inode, socketPort := range sockets {
+		if socketPort == port {
+			portInodes[inode] = true
+		}
+	}
+	if len(portInodes) == 0 {
+		return false, nil, nil
+	}
+
+	procs, err := procfs.AllProcs()
+	if err != nil {
+		return true, nil, err
+	}
+	pids := make([]int, 0)
+	for _, proc := range procs {
+		inodes, err := socketInodes(proc)
+		if err != nil {
+			continue
+		}
+		for _, inode := range inodes {
+			if portInodes[inode] {
+				pids = append(pids, proc.PID)
+				break
+			}
+		}
+	}
+	return true, pids, nil
+}
+
+// JoinPorts formats ports as a comma separated list
+func JoinPorts(ports []int) string {
+	values := make([]string, 0, len(ports))
+	for _, port := range ports {
+		values = append(values, strconv.Itoa(port))
+	}
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	ProbeTCP  = "tcp"
+	ProbeHTTP = "http"
+	ProbeExec = "exec"
+)
+
+// Probe checks that a service answers. Depending on Type:
+// tcp connects to Address, http expects a 2xx/3xx response from URL, exec
+// expects Command to exit with status 0.
+type Probe struct {
+	Type    string
+	Address string
+	URL     string
+	Command []string
+	Timeout time.Duration
+}
+
+func (probe Probe) String() string {
+	switch probe.Type {
+	case ProbeTCP:
+		return fmt.Sprintf("tcp %s", probe.Address)
+	case ProbeHTTP:
+		return fmt.Sprintf("http %s", probe.URL)
+	case ProbeExec:
+		return fmt.Sprintf("exec %s", strings.Join(probe.Command, " "))
+	default:
+		return probe.Type
+	}
+}
+
+func (probe Probe) Check() error {
+	switch probe.Type {
+	case ProbeTCP:
+		conn, err := net.DialTimeout("tcp", probe.Address, probe.Timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case ProbeHTTP:
+		client := http.Client{Timeout: probe.Timeout}
+		resp, err := client.Get(probe.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			errMsg := fmt.Sprintf("%s returned %s", probe.URL, resp.Status)
+			return errors.New(errMsg)
+		}
+		return nil
+	case ProbeExec:
+		if len(probe.Command) == 0 {
+			return errors.New("Empty exec probe command")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), probe.Timeout)
+		defer cancel()
+		output, err := exec.CommandContext(ctx, probe.Command[0], probe.Command[1:]...).CombinedOutput()
+		if err != nil {
+			errMsg := fmt.Sprintf("%s: %s %s", probe, err, strings.TrimSpace(string(output)))
+			return errors.New(errMsg)
+		}
+		return nil
+	default:
+		errMsg := fmt.Sprintf("Unsupported probe type '%s'", probe.Type)
+		return errors.New(errMsg)
+	}
+}
+
+// CheckProbes runs all probes, returning the first failure
+func CheckProbes(probes []Probe) error {
+	for _, probe := range probes {
+		err := probe.Check()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
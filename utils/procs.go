@@ -3,70 +3,147 @@ package utils
 import (
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
-	"strconv"
-	"strings"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/procfs"
 )
 
+// GetMatchingPids returns the pids of processes matching cmdArgs in the
+// process table snapshot shared by the current command.
 func GetMatchingPids(cmdArgs []string) ([]int, error) {
-	cmdString := strings.Join(cmdArgs, " ")
-	psString := fmt.Sprintf("ps aux | grep -v grep | grep -e '%s' | awk '{print $2}'", cmdString)
-	psOutput, _ := exec.Command("sh", "-c", psString).Output()
-	pids := make([]int, 0)
-	for _, pid := range strings.Split(string(psOutput), "\n") {
-		if pid != "" {
-			pidVal, err := strconv.Atoi(pid)
-			if err != nil {
-				continue
-			}
-			pids = append(pids, pidVal)
-		}
+	table, err := CurrentProcTable()
+	if err != nil {
+		return nil, err
+	}
+	return table.Match(cmdArgs)
+}
+
+// ProcessMatches returns true if the command line of pid matches cmdArgs
+func ProcessMatches(pid int, cmdArgs []string) bool {
+	proc, err := procfs.NewProc(pid)
+	if err != nil {
+		return false
+	}
+	cmdline, err := proc.CmdLine()
+	if err != nil {
+		return false
 	}
-	return pids, nil
+	patterns, err := compileArgPatterns(cmdArgs)
+	if err != nil {
+		return false
+	}
+	return matchArgPatterns(patterns, cmdline)
 }
 
-func RunDetachedProcess(logPath string, cmdArgs []string) error {
+// LauncherCommand is the hidden opsctl command applying limits before
+// exec'ing an instance command, see Launch
+const LauncherCommand = "launch"
+
+// SpawnOptions tell how an instance process is started
+type SpawnOptions struct {
+	Limits ProcessLimits
+	// cgroup the process is moved into before exec'ing, if set
+	CgroupPath string
+	// User and groups the process runs as, the current ones if nil
+	Credential *syscall.Credential
+	// KEY=value environment of the process
+	Env []string
+}
+
+// RunDetachedProcess starts cmdArgs in the background according to options
+// and returns its pid
+func RunDetachedProcess(logPath string, cmdArgs []string, options SpawnOptions) (int, error) {
 	// Check that executable exists
 	executable, err := os.Stat(cmdArgs[0])
 	if err != nil {
-		log.Printf("Package binary %s not found", cmdArgs[0])
-		return err
+		errMsg := fmt.Sprintf("Package binary %s not found", cmdArgs[0])
+		return -1, errors.New(errMsg)
 	}
 
 	if executable.IsDir() {
 		errMsg := fmt.Sprintf("%s is not a file.", cmdArgs[0])
-		log.Printf(errMsg)
-		return errors.New(errMsg)
+		return -1, errors.New(errMsg)
 	}
 
 	// Open log file (append if exists else create)
 	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		errMsg := fmt.Sprintf("Failed creating log file %s", logPath)
-		log.Printf(errMsg)
-		return errors.New(errMsg)
+		return -1, errors.New(errMsg)
+	}
+	defer f.Close()
+	// Create command: opsctl re-executes itself to apply limits, then execs
+	// the actual command, like nohup would
+	self, err := os.Executable()
+	if err != nil {
+		return -1, err
+	}
+	launcherArgs := []string{LauncherCommand}
+	launcherArgs = append(launcherArgs, options.Limits.LauncherArgs()...)
+	// The launcher waits to be moved into the cgroup by opsctl, which may do
+	// so after privileges are dropped
+	var cgroupWait, cgroupReady *os.File
+	if options.CgroupPath != "" {
+		cgroupWait, cgroupReady, err = os.Pipe()
+		if err != nil {
+			return -1, err
+		}
+		defer cgroupWait.Close()
+		defer cgroupReady.Close()
+		launcherArgs = append(launcherArgs, "--wait-cgroup")
+	}
+	launcherArgs = append(launcherArgs, "--")
+	launcherArgs = append(launcherArgs, cmdArgs...)
+	cmdR := exec.Command(self, launcherArgs...)
+	// Nothing from the opsctl environment leaks into the process
+	cmdR.Env = options.Env
+	if cmdR.Env == nil {
+		cmdR.Env = []string{}
 	}
-	// Create command
-	cmdR := exec.Command("nohup", cmdArgs...)
 	// Redirect both stdout and stderr to the log file
 	cmdR.Stdout = f
 	cmdR.Stderr = f
+	if cgroupWait != nil {
+		// fd 3 of the launcher
+		cmdR.ExtraFiles = []*os.File{cgroupWait}
+	}
+	// Run in its own session so that signals sent to opsctl (e.g. Ctrl-C, or
+	// SIGTERM to a supervisor) do not reach the instance
+	cmdR.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Credential: options.Credential}
 	// Run the process
 	err = cmdR.Start()
 	if err != nil {
-		log.Printf("Failed starting.")
-		return err
+		errMsg := fmt.Sprintf("Failed starting: %s", err)
+		return -1, errors.New(errMsg)
 	}
-	return nil
+	if cgroupReady != nil {
+		err = JoinCgroup(options.CgroupPath, cmdR.Process.Pid)
+		cgroupReady.Close()
+		if err != nil {
+			cmdR.Process.Kill()
+			cmdR.Wait()
+			return -1, err
+		}
+	}
+	// Reap the process once it exits, otherwise it stays a zombie of
+	// long-running callers such as the supervisor
+	go cmdR.Wait()
+	// The launcher execs the actual command, so the pid is kept
+	return cmdR.Process.Pid, nil
 }
 
-func WaitForProcess(cmdArgs []string, gracePeriod time.Duration) (int, error) {
+// WaitForProcess waits for the process started as pid to show up with a
+// command line matching cmdArgs. Falls back to any single matching process
+// for commands which fork into a different pid.
+func WaitForProcess(pid int, cmdArgs []string, gracePeriod time.Duration) (int, error) {
 	for start := time.Now(); time.Since(start) < gracePeriod; {
+		if ProcessMatches(pid, cmdArgs) {
+			return pid, nil
+		}
+		RefreshProcTable()
 		pids, _ := GetMatchingPids(cmdArgs)
 		if len(pids) == 1 {
 			return pids[0], nil
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/prometheus/procfs"
+)
+
+// ProcEntry is a process as seen in a ProcTable snapshot
+type ProcEntry struct {
+	PID       int
+	Cmdline   []string
+	StartTime uint64
+}
+
+// ProcTable is a snapshot of all running processes, read once from /proc
+type ProcTable struct {
+	Procs []ProcEntry
+}
+
+var (
+	procTableMutex sync.Mutex
+	procTable      *ProcTable
+)
+
+func SnapshotProcTable() (*ProcTable, error) {
+	procs, err := procfs.AllProcs()
+	if err != nil {
+		return nil, err
+	}
+	table := ProcTable{Procs: make([]ProcEntry, 0, len(procs))}
+	for _, proc := range procs {
+		// Processes may exit while /proc is being walked
+		cmdline, err := proc.CmdLine()
+		if err != nil || len(cmdline) == 0 {
+			// Kernel threads and zombies have an empty command line
+			continue
+		}
+		stat, err := proc.Stat()
+		if err != nil || stat.State == "Z" {
+			continue
+		}
+		table.Procs = append(table.Procs, ProcEntry{
+			PID:       proc.PID,
+			Cmdline:   cmdline,
+			StartTime: stat.Starttime,
+		})
+	}
+	return &table, nil
+}
+
+// CurrentProcTable returns the snapshot shared by everything running in this
+// opsctl command, taking it on first use.
+func CurrentProcTable() (*ProcTable, error) {
+	procTableMutex.Lock()
+	defer procTableMutex.Unlock()
+	if procTable == nil {
+		table, err := SnapshotProcTable()
+		if err != nil {
+			return nil, err
+		}
+		procTable = table
+	}
+	return procTable, nil
+}
+
+// RefreshProcTable drops the shared snapshot so the next lookup re-reads /proc.
+// Must be called whenever processes are started or stopped.
+func RefreshProcTable() {
+	procTableMutex.Lock()
+	defer procTableMutex.Unlock()
+	procTable = nil
+}
+
+// Match returns the pids of all processes whose argv matches cmdArgs
+func (table ProcTable) Match(cmdArgs []string) ([]int, error) {
+	patterns, err := compileArgPatterns(cmdArgs)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, 0)
+	for _, entry := range table.Procs {
+		if matchArgPatterns(patterns, entry.Cmdline) {
+			pids = append(pids, entry.PID)
+		}
+	}
+	return pids, nil
+}
+
+// QuoteArgs escapes literal arguments to be used as runtime match patterns
+func QuoteArgs(args []string) []string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = regexp.QuoteMeta(arg)
+	}
+	return quoted
+}
+
+// Each runtime argument is a regex anchored on a whole argv element
+func compileArgPatterns(cmdArgs []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, len(cmdArgs))
+	for i, arg := range cmdArgs {
+		pattern, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", arg))
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid runtime pattern '%s': %s", arg, err)
+			return nil, errors.New(errMsg)
+		}
+		patterns[i] = pattern
+	}
+	return patterns, nil
+}
+
+// The first pattern must match the executable (argv[0]). The following
+// patterns must match arguments in the same order, other arguments
+// (e.g. JVM options) being allowed in between.
+func matchArgPatterns(patterns []*regexp.Regexp, argv []string) bool {
+	if len(patterns) == 0 || len(argv) == 0 {
+		return false
+	}
+	if !patterns[0].MatchString(argv[0]) {
+		return false
+	}
+	next := 1
+	for _, arg := range argv[1:] {
+		if next == len(patterns) {
+			break
+		}
+		if patterns[next].MatchString(arg) {
+			next++
+		}
+	}
+	return next == len(patterns)
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metric is a metric family rendered in the Prometheus text exposition format
+type Metric struct {
+	Name    string
+	Help    string
+	Type    string // gauge or counter
+	Samples []Sample
+}
+
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+func (metric *Metric) Add(labels map[string]string, value float64) {
+	metric.Samples = append(metric.Samples, Sample{Labels: labels, Value: value})
+}
+
+// WriteMetrics writes metrics in the Prometheus text format (version 0.0.4)
+func WriteMetrics(w io.Writer, metrics []*Metric) error {
+	for _, metric := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.Name, escapeHelp(metric.Help), metric.Name, metric.Type)
+		if err != nil {
+			return err
+		}
+		for _, sample := range metric.Samples {
+			_, err = fmt.Fprintf(w, "%s%s %s\n", metric.Name, formatLabels(sample.Labels), strconv.FormatFloat(sample.Value, 'g', -1, 64))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(labels[name])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/prometheus/procfs"
+)
+
+// LookupCredential resolves a user, and optionally a group, to the credential
+// a process is started with. Names or numeric ids are accepted. The group
+// defaults to the primary group of the user.
+func LookupCredential(userName string, groupName string) (*syscall.Credential, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		u, err = user.LookupId(userName)
+	}
+	if err != nil {
+		errMsg := fmt.Sprintf("Unknown user '%s'", userName)
+		return nil, errors.New(errMsg)
+	}
+	uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			g, err = user.LookupGroupId(groupName)
+		}
+		if err != nil {
+			errMsg := fmt.Sprintf("Unknown group '%s'", groupName)
+			return nil, errors.New(errMsg)
+		}
+		gid, _ = strconv.ParseUint(g.Gid, 10, 32)
+	}
+
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{}}
+	groupIds, err := u.GroupIds()
+	if err == nil {
+		for _, groupId := range groupIds {
+			id, err := strconv.ParseUint(groupId, 10, 32)
+			if err == nil {
+				credential.Groups = append(credential.Groups, uint32(id))
+			}
+		}
+	}
+	return credential, nil
+}
+
+// Permissions checked by HasPermission
+const (
+	PermExecute = 01
+	PermWrite   = 02
+)
+
+// HasPermission tells whether the owner, group or other permission bits of
+// path grant perm to credential
+func HasPermission(path string, credential *syscall.Credential, perm os.FileMode) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if credential.Uid == 0 {
+		return true, nil
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, nil
+	}
+	mode := info.Mode().Perm()
+	if stat.Uid == credential.Uid {
+		return mode&(perm<<6) != 0, nil
+	}
+	groups := append([]uint32{credential.Gid}, credential.Groups...)
+	for _, gid := range groups {
+		if stat.Gid == gid {
+			return mode&(perm<<3) != 0, nil
+		}
+	}
+	return mode&perm != 0, nil
+}
+
+// ProcessOwner returns the name of the effective user of pid, or its uid
+// when it has no name
+func ProcessOwner(pid int) (string, error) {
+	proc, err := procfs.NewProc(pid)
+	if err != nil {
+		return "", err
+	}
+	status, err := proc.NewStatus()
+	if err != nil {
+		return "", err
+	}
+	// UIDs are strings up to procfs v0.7, integers after: format either
+	uid := fmt.Sprint(status.UIDs[1])
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return uid, nil
+	}
+	return u.Username, nil
+}